@@ -48,7 +48,7 @@ func initAuthHandlers(root *handlers.HandlerManager, fcInChan chan uavtalk.Packe
 
 	filter := func(i interface{}) (interface{}, bool) {
 		p := i.(uavtalk.Packet)
-		return i, authPackets.contains(p.Definition.Name)
+		return i, p.Definition != nil && authPackets.contains(p.Definition.Name)
 	}
 
 	connected := false
@@ -94,7 +94,7 @@ func initAuthHandlers(root *handlers.HandlerManager, fcInChan chan uavtalk.Packe
 					numberOfObjects = _numberOfObjects
 				}
 				if p.Cmd == uavtalk.ObjectCmdWithAck {
-					sessionManagingPacketAck := uavtalk.CreatePacketAck(p.Definition)
+					sessionManagingPacketAck := uavtalk.CreatePacketAck(p.Definition, p.InstanceID)
 					fcInChan <- sessionManagingPacketAck
 
 					objectID := p.Data["ObjectID"].(uint32)
@@ -104,7 +104,7 @@ func initAuthHandlers(root *handlers.HandlerManager, fcInChan chan uavtalk.Packe
 							log.Warning(err)
 						} else {
 							// TODO create rotonde definition
-							activeDefinitions = append(activeDefinitions, definition)
+							activeDefinitions = appendDefinition(activeDefinitions, definition)
 						}
 					}
 
@@ -113,27 +113,22 @@ func initAuthHandlers(root *handlers.HandlerManager, fcInChan chan uavtalk.Packe
 						log.Info("Available Definitions fetch done.")
 						spent := time.Now().Sub(start).Seconds()
 						go func() {
-							meta := map[string]interface{}{
-								"modes": float64(0), "periodFlight": float64(0), "periodGCS": float64(0), "periodLog": float64(0),
-							}
 							if spent < SESSION_PAUSE {
 								time.Sleep(time.Duration(float64(SESSION_PAUSE)-spent) * time.Second)
 							}
 							for _, definition := range activeDefinitions {
-								modes := 0
 								log.Info("sending definition", definition.Name)
 
-								if definition.TelemetryFlight.Acked {
-									modes |= 1 << 2
+								metadata := uavtalk.Metadata{
+									FlightTelemetryAcked: definition.TelemetryFlight.Acked,
+									GcsTelemetryAcked:    definition.TelemetryGcs.Acked,
 								}
-								if definition.TelemetryGcs.Acked {
-									modes |= 1 << 3
+								packet, err := uavtalk.CreateMetadataSetter(definition.Name, &metadata)
+								if err != nil {
+									log.Warning(err)
+									continue
 								}
-
-								meta["modes"] = float64(modes)
-
-								setter := uavtalk.CreateObjectSetter(definition.Meta.Name, 0, meta)
-								fcInChan <- *setter
+								fcInChan <- packet
 								time.Sleep(50 * time.Millisecond)
 							}
 
@@ -191,18 +186,25 @@ func initStreamHandlers(root *handlers.HandlerManager, fcInChan chan uavtalk.Pac
 
 	filter := func(i interface{}) (interface{}, bool) {
 		p := i.(uavtalk.Packet)
-		return i, authPackets.contains(p.Definition.Name) == false
+		// unknown objects passed through have no name to be forwarded with
+		return i, p.Definition != nil && authPackets.contains(p.Definition.Name) == false
 	}
 
 	handler := func(i interface{}) bool {
 		p := i.(uavtalk.Packet)
 		if p.Cmd == uavtalk.ObjectCmdWithAck {
-			fcInChan <- uavtalk.CreatePacketAck(p.Definition)
+			fcInChan <- uavtalk.CreatePacketAck(p.Definition, p.InstanceID)
 		} else if p.Cmd == uavtalk.ObjectAck {
 			// send ObjectPersistence when received a Ack for object with Settings == true
 			if p.Definition != objectPersistenceDefinition && p.Definition.Settings == true {
 				fcInChan <- uavtalk.CreatePersistObject(p.Definition, p.InstanceID)
 			}
+		} else if p.Cmd == uavtalk.ObjectNack {
+			if status, ok := p.Data["Status"]; ok {
+				log.Warningf("Received Nack for %s, status %v", p.Definition.Name, status)
+			} else {
+				log.Warningf("Received Nack for %s", p.Definition.Name)
+			}
 		}
 		if event := toRotondePacket(p); event != nil {
 			client.SendMessage(event)
@@ -219,7 +221,7 @@ func initStreamHandlers(root *handlers.HandlerManager, fcInChan chan uavtalk.Pac
 
 func main() {
 	if len(os.Args) < 2 {
-		log.Fatal(fmt.Sprintf("Usage: %s common_directory/", os.Args[0]))
+		log.Fatal(fmt.Sprintf("Usage: %s common_directory/ [overlay_directory/...]", os.Args[0]))
 	}
 
 	fcInChan := make(chan uavtalk.Packet, 100)
@@ -233,7 +235,16 @@ func main() {
 		return true
 	})
 
-	uavtalk.LoadDefinitions(os.Args[1])
+	uavtalk.LoadDefinitions(os.Args[1:]...)
+
+	// lets clients know when the data they hold is stale
+	linkDefinition := rotonde.Definition{"UAVTALK_LINK", "event", false, []*rotonde.FieldDefinition{}}
+	linkDefinition.PushField("up", "boolean", "")
+	client.AddLocalDefinition(&linkDefinition)
+	uavtalk.LinkStateHandler = func(up bool) {
+		client.SendMessage(rotonde.Event{"UAVTALK_LINK", map[string]interface{}{"up": up}})
+	}
+
 	go uavtalk.Start(fcInChan, fcOutChan)
 	rootOut := handlers.NewHandlerManager(chanCast(fcOutChan), handlers.PassAll, handlers.Noop, handlers.Noop)
 	initAuthHandlers(rootOut, fcInChan, client)
@@ -256,6 +267,17 @@ func chanCast(inChan chan uavtalk.Packet) chan interface{} {
 	return outChan
 }
 
+// appendDefinition appends definition, replacing the one with the same ObjectID if already there
+func appendDefinition(definitions []*uavtalk.Definition, definition *uavtalk.Definition) []*uavtalk.Definition {
+	for i, d := range definitions {
+		if d.ObjectID == definition.ObjectID {
+			definitions[i] = definition
+			return definitions
+		}
+	}
+	return append(definitions, definition)
+}
+
 func sendAsRotondeDefinitions(definition *uavtalk.Definition, client *client.Client) {
 	name := strings.ToUpper(definition.Name)
 
@@ -285,7 +307,7 @@ func sendAsRotondeDefinitions(definition *uavtalk.Definition, client *client.Cli
 }
 
 func toRotondePacket(p uavtalk.Packet) interface{} {
-	if p.Cmd != uavtalk.ObjectCmd && p.Cmd != uavtalk.ObjectCmdWithAck {
+	if p.Definition == nil || (p.Cmd != uavtalk.ObjectCmd && p.Cmd != uavtalk.ObjectCmdWithAck) {
 		return nil
 	}
 	name := strings.ToUpper(p.Definition.Name)
@@ -0,0 +1,177 @@
+package uavtalk
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// CRCErrorThreshold is the number of checksum errors within CRCErrorWindow above which the link
+// is considered out of sync, the accumulator is then flushed and the link reopened.
+// Zero disables the check.
+var CRCErrorThreshold = 20
+
+// CRCErrorWindow is the duration over which checksum errors are counted
+var CRCErrorWindow = 5 * time.Second
+
+// DecodeWorkers is the number of goroutines decoding in parallel the frames completed by a single read,
+// packets are still handled in the order of their frames. 0 or 1 decodes in the reading goroutine,
+// more only helps when reads hold many frames, as on serial or tcp links at high telemetry rates.
+var DecodeWorkers = 0
+
+// accumulator holds the bytes read from the link until they form complete packets.
+// cursor is where the next scan resumes, consumed bytes are only dropped once per readPackets call.
+type accumulator struct {
+	buffer []byte
+	cursor int
+
+	clock          Clock
+	checksumErrors []time.Time
+	checksum       Checksum
+	skipChecksum   bool
+	// offline accumulators decode streams unrelated to the link, their failed frames are not requested again
+	offline bool
+
+	// frames completed by the current readPackets call, kept for the decode workers
+	frames [][]byte
+}
+
+func newAccumulator() *accumulator {
+	return &accumulator{buffer: make([]byte, 0, 4096), clock: DefaultClock, checksum: FrameChecksum}
+}
+
+// newOfflineAccumulator returns an accumulator for streams read outside of the link, see StreamDecoder
+func newOfflineAccumulator() *accumulator {
+	acc := newAccumulator()
+	acc.offline = true
+	return acc
+}
+
+func (acc *accumulator) write(b []byte) {
+	acc.buffer = append(acc.buffer, b...)
+}
+
+// readPackets decodes all the complete packets found in the accumulator and passes them to handler,
+// it returns ErrOutOfSync, after flushing the accumulator, when CRCErrorThreshold is exceeded.
+// The packets completed before the flush are still passed to handler.
+func (acc *accumulator) readPackets(handler func(*Packet)) error {
+	resync := false
+	workers := DecodeWorkers
+	acc.frames = acc.frames[:0]
+	for {
+		ok, from, to, err := packetComplete(acc.buffer, acc.cursor, acc.checksum, acc.skipChecksum == false)
+		if err == nil {
+			if ok != true {
+				acc.cursor = from
+				break
+			}
+
+			if skipDecoding(acc.buffer[from:to]) {
+				// not in the decode allowlist
+			} else if workers > 1 {
+				acc.frames = append(acc.frames, acc.buffer[from:to])
+			} else if uavTalkObject, err := newPacketFromBinary(acc.buffer[from:to], acc.checksum.Size()); err == nil {
+				handler(uavTalkObject)
+			} else {
+				log.Warning(err)
+				PrintHex(acc.buffer[from:to], to-from)
+				acc.frameFailed(acc.buffer[from:to])
+			}
+		} else {
+			// the packet is complete but its integrity is seriously questionned,
+			// we go through so we can strip it from buffer
+			log.Warning(err)
+			PrintHex(acc.buffer[from:to], to-from)
+			if err == ErrBadCRC {
+				acc.frameFailed(acc.buffer[from:to])
+				if acc.checksumError() {
+					resync = true
+				}
+			}
+		}
+		acc.cursor = to
+	}
+
+	if len(acc.frames) > 0 {
+		// before the buffer is flushed or compacted, which would overwrite the frames
+		acc.decodeFrames(workers, handler)
+	}
+
+	if resync {
+		acc.flush()
+		return ErrOutOfSync
+	}
+
+	n := copy(acc.buffer, acc.buffer[acc.cursor:])
+	acc.buffer = acc.buffer[:n]
+	acc.cursor = 0
+	return nil
+}
+
+// decodeFrames decodes acc.frames with workers goroutines, then passes the packets to handler in the order of the frames
+func (acc *accumulator) decodeFrames(workers int, handler func(*Packet)) {
+	frames := acc.frames
+	checksumSize := acc.checksum.Size()
+	packets := make([]*Packet, len(frames))
+	errs := make([]error, len(frames))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(frames); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				packets[i], errs[i] = newPacketFromBinary(frames[i], checksumSize)
+			}
+		}()
+	}
+	for i := range frames {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for i, packet := range packets {
+		if errs[i] != nil {
+			log.Warning(errs[i])
+			PrintHex(frames[i], len(frames[i]))
+			acc.frameFailed(frames[i])
+			continue
+		}
+		handler(packet)
+	}
+}
+
+// frameFailed records a failed frame for re-requests, unless the accumulator is offline
+func (acc *accumulator) frameFailed(frame []byte) {
+	if acc.offline == false {
+		frameFailed(frame, acc.clock.Now())
+	}
+}
+
+// checksumError records a checksum error, and tells whether CRCErrorThreshold is now exceeded
+func (acc *accumulator) checksumError() bool {
+	if CRCErrorThreshold <= 0 {
+		return false
+	}
+	now := acc.clock.Now()
+	recent := acc.checksumErrors[:0]
+	for _, t := range acc.checksumErrors {
+		if now.Sub(t) < CRCErrorWindow {
+			recent = append(recent, t)
+		}
+	}
+	acc.checksumErrors = append(recent, now)
+	return len(acc.checksumErrors) > CRCErrorThreshold
+}
+
+// flush drops everything accumulated so far, including the checksum errors count,
+// so the next packet is looked for from the next sync byte written.
+// readPackets has already consumed the complete packets, only partial ones are lost.
+func (acc *accumulator) flush() {
+	acc.buffer = acc.buffer[:0]
+	acc.cursor = 0
+	acc.checksumErrors = acc.checksumErrors[:0]
+}
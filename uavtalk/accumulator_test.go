@@ -0,0 +1,170 @@
+package uavtalk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccumulatorSplitReads(t *testing.T) {
+	loadTestDefinitions(t)
+	defer func() { DecodeWorkers = 0 }()
+
+	var stream []byte
+	stream = append(stream, 0x3c, 0x00)
+	stream = append(stream, encodeTestPacket(t, "AttitudeActual", ObjectCmd, 0, attitudeData())...)
+	stream = append(stream, 0xff, 0x3c)
+	stream = append(stream, encodeTestPacket(t, "Waypoint", ObjectCmd, 1, waypointData())...)
+	stream = append(stream, encodeTestPacket(t, "Label", ObjectCmd, 0, labelData())...)
+	stream = append(stream, encodeTestPacket(t, "Waypoint", ObjectRequest, 2, nil)...)
+	expected := []string{"AttitudeActual", "Waypoint", "Label", "Waypoint"}
+
+	for _, workers := range []int{0, 4} {
+		DecodeWorkers = workers
+		for _, readSize := range []int{1, 7, 64, len(stream)} {
+			acc := newAccumulator()
+			var names []string
+			for offset := 0; offset < len(stream); offset += readSize {
+				end := offset + readSize
+				if end > len(stream) {
+					end = len(stream)
+				}
+				acc.write(stream[offset:end])
+				if err := acc.readPackets(func(packet *Packet) {
+					names = append(names, packet.Definition.Name)
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(names) != len(expected) {
+				t.Errorf("%d workers, reads of %d bytes: decoded %v", workers, readSize, names)
+				continue
+			}
+			for i := range names {
+				if names[i] != expected[i] {
+					t.Errorf("%d workers, reads of %d bytes: decoded %v", workers, readSize, names)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestAccumulatorChecksumErrors(t *testing.T) {
+	loadTestDefinitions(t)
+	defer func() { CRCErrorThreshold = 20 }()
+	CRCErrorThreshold = 2
+
+	frame := encodeTestPacket(t, "AttitudeActual", ObjectCmd, 0, attitudeData())
+	corrupted := corruptFrame(frame)
+
+	clock := newManualClock()
+	acc := newAccumulator()
+	acc.clock = clock
+	count := 0
+	handler := func(*Packet) { count++ }
+
+	tests := []struct {
+		write   []byte
+		elapsed time.Duration
+		resync  bool
+		count   int
+	}{
+		{corrupted, 0, false, 0},
+		{frame, time.Second, false, 1},
+		{corrupted, time.Second, false, 1},
+		// third error within CRCErrorWindow, the frame before it still makes it
+		{append(append([]byte(nil), frame...), corrupted...), time.Second, true, 2},
+		{corrupted, 10 * time.Second, false, 2},
+	}
+	for i, test := range tests {
+		clock.Advance(test.elapsed)
+		acc.write(test.write)
+		err := acc.readPackets(handler)
+		if (err == ErrOutOfSync) != test.resync || count != test.count {
+			t.Errorf("case %d: got %v with %d packets, expected resync %t and %d packets", i, err, count, test.resync, test.count)
+		}
+	}
+}
+
+// benchmarkDecode feeds the frame of a packet to an accumulator, one frame per read
+func benchmarkDecode(b *testing.B, name string, instanceID uint16, data map[string]interface{}) {
+	loadTestDefinitions(b)
+	frame := encodeTestPacket(b, name, ObjectCmd, instanceID, data)
+	acc := newAccumulator()
+	count := 0
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(frame)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		acc.write(frame)
+		if err := acc.readPackets(func(*Packet) { count++ }); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	if count != b.N {
+		b.Fatalf("%d packets decoded out of %d", count, b.N)
+	}
+}
+
+// BenchmarkDecode measures packetComplete, newPacketFromBinary and uAVTalkToMap for a single instance object
+func BenchmarkDecode(b *testing.B) {
+	benchmarkDecode(b, "AttitudeActual", 0, attitudeData())
+}
+
+// BenchmarkDecodeMultiInstance is BenchmarkDecode for a multi instance object with array and enum fields
+func BenchmarkDecodeMultiInstance(b *testing.B) {
+	benchmarkDecode(b, "Waypoint", 3, waypointData())
+}
+
+func TestAccumulatorKeepsUnscannedBytes(t *testing.T) {
+	loadTestDefinitions(t)
+	frame := encodeTestPacket(t, "AttitudeActual", ObjectCmd, 0, attitudeData())
+	headerLength := Layout.length(true)
+
+	tests := []struct {
+		write []byte
+		kept  int
+	}{
+		// bytes without sync byte are dropped once scanned, except the ones that can still start a header
+		{make([]byte, 100), headerLength - 1},
+		{frame[:len(frame)-1], len(frame) - 1},
+		{append(append([]byte(nil), frame...), frame[:3]...), 3},
+	}
+	for i, test := range tests {
+		acc := newAccumulator()
+		acc.write(test.write)
+		if err := acc.readPackets(func(*Packet) {}); err != nil {
+			t.Fatal(err)
+		}
+		if len(acc.buffer) != test.kept || acc.cursor != 0 {
+			t.Errorf("case %d: %d bytes kept, cursor %d, expected %d bytes", i, len(acc.buffer), acc.cursor, test.kept)
+		}
+	}
+}
+
+// BenchmarkReadPacketsManyPerRead decodes reads holding many frames, each frame being scanned once
+func BenchmarkReadPacketsManyPerRead(b *testing.B) {
+	loadTestDefinitions(b)
+	var read []byte
+	for i := 0; i < 50; i++ {
+		read = append(read, encodeTestPacket(b, "Waypoint", ObjectCmd, uint16(i), waypointData())...)
+	}
+	acc := newAccumulator()
+	count := 0
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(read)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		acc.write(read)
+		if err := acc.readPackets(func(*Packet) { count++ }); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	if count != 50*b.N {
+		b.Fatalf("%d packets decoded out of %d", count, 50*b.N)
+	}
+}
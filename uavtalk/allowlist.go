@@ -0,0 +1,52 @@
+package uavtalk
+
+import "sync"
+
+/**
+ * The decode allowlist restricts which objects are decoded when received,
+ * frames of the other objects are still delimited and checked but their body is skipped.
+ * Only plain ObjectCmd frames are skipped, the ones needing an ack always make it to outChan.
+ * When used, the objects the handshake relies on (FlightTelemetryStats, SessionManaging...) have to be allowed too.
+ */
+
+var decodeAllowlistLock sync.RWMutex
+var decodeAllowlist map[uint32]bool
+
+// AllowDecoding adds the objects with the given names to the decode allowlist, enabling it if it was not
+func AllowDecoding(names ...string) error {
+	decodeAllowlistLock.Lock()
+	defer decodeAllowlistLock.Unlock()
+
+	allowlist := make(map[uint32]bool, len(decodeAllowlist)+len(names))
+	for objectID := range decodeAllowlist {
+		allowlist[objectID] = true
+	}
+	for _, name := range names {
+		definition, err := AllDefinitions.GetDefinitionForName(name)
+		if err != nil {
+			return err
+		}
+		// as read from the header, which may not hold the whole id
+		allowlist[definition.ObjectID&Layout.objectIDMask()] = true
+	}
+	decodeAllowlist = allowlist
+	return nil
+}
+
+// ClearDecodeAllowlist disables the decode allowlist, all objects are decoded again
+func ClearDecodeAllowlist() {
+	decodeAllowlistLock.Lock()
+	defer decodeAllowlistLock.Unlock()
+	decodeAllowlist = nil
+}
+
+// skipDecoding tells whether a complete frame can be dropped without being decoded
+func skipDecoding(frame []byte) bool {
+	decodeAllowlistLock.RLock()
+	defer decodeAllowlistLock.RUnlock()
+	// timestamped frames are plain ObjectCmd frames too
+	if decodeAllowlist == nil || frame[1]&^timestampedMask != ObjectCmd|versionMask {
+		return false
+	}
+	return decodeAllowlist[Layout.readObjectID(frame)] == false
+}
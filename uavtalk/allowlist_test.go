@@ -0,0 +1,38 @@
+package uavtalk
+
+import "testing"
+
+func TestSkipDecoding(t *testing.T) {
+	loadTestDefinitions(t)
+	defer ClearDecodeAllowlist()
+
+	allowed := encodeTestPacket(t, "AttitudeActual", ObjectCmd, 0, attitudeData())
+	other := encodeTestPacket(t, "Label", ObjectCmd, 0, labelData())
+	timestamped := append([]byte(nil), other...)
+	timestamped[1] |= timestampedMask
+	withAck := encodeTestPacket(t, "Label", ObjectCmdWithAck, 0, labelData())
+
+	tests := []struct {
+		frame     []byte
+		allowlist bool
+		skipped   bool
+	}{
+		{other, false, false},
+		{allowed, true, false},
+		{other, true, true},
+		{timestamped, true, true},
+		{withAck, true, false},
+	}
+
+	for i, test := range tests {
+		ClearDecodeAllowlist()
+		if test.allowlist {
+			if err := AllowDecoding("AttitudeActual"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if skipDecoding(test.frame) != test.skipped {
+			t.Errorf("case %d: skipped %t, expected %t", i, !test.skipped, test.skipped)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package uavtalk
+
+import (
+	"sync"
+	"sync/atomic"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+/**
+ * outChan is the backlog between the link reader and whoever consumes the decoded packets,
+ * when it is full the reader either waits (and stops reading the link) or drops the packet.
+ */
+
+// BacklogPolicy tells the link reader what to do when outChan is full
+type BacklogPolicy int
+
+const (
+	// BlockOnFullBacklog waits for the consumer, with a warning
+	BlockOnFullBacklog BacklogPolicy = iota
+	// DropOnFullBacklog drops the packet and counts it
+	DropOnFullBacklog
+)
+
+// OutBacklogPolicy is the policy applied by the link reader, defaults to BlockOnFullBacklog
+var OutBacklogPolicy = BlockOnFullBacklog
+
+// the channels given to Start, set before the link is opened
+var backlogLock sync.Mutex
+var inBacklog, outBacklog chan Packet
+var droppedPackets uint64
+
+func setBacklogs(inChan chan Packet, outChan chan Packet) {
+	backlogLock.Lock()
+	defer backlogLock.Unlock()
+	inBacklog, outBacklog = inChan, outChan
+}
+
+// BacklogDepth returns the number of decoded packets waiting to be consumed in outChan
+func BacklogDepth() int {
+	backlogLock.Lock()
+	defer backlogLock.Unlock()
+	return len(outBacklog)
+}
+
+// InBacklogDepth returns the number of packets waiting to be sent in inChan
+func InBacklogDepth() int {
+	backlogLock.Lock()
+	defer backlogLock.Unlock()
+	return len(inBacklog)
+}
+
+// DroppedPackets returns the number of packets dropped because outChan was full
+func DroppedPackets() uint64 {
+	return atomic.LoadUint64(&droppedPackets)
+}
+
+// pushOut hands packet over to the consumer of outChan according to OutBacklogPolicy,
+// it returns false when quit is closed while blocked on a full backlog
+func pushOut(outChan chan Packet, packet Packet, quit chan struct{}) bool {
+	select {
+	case outChan <- packet:
+		return true
+	default:
+	}
+
+	if OutBacklogPolicy == DropOnFullBacklog {
+		dropped := atomic.AddUint64(&droppedPackets, 1)
+		if packet.Definition == nil {
+			log.Warningf("Backlog full, dropping unknown object %d (%d dropped so far)", packet.ObjectID, dropped)
+		} else {
+			log.Warningf("Backlog full, dropping %s (%d dropped so far)", packet.Definition.Name, dropped)
+		}
+		return true
+	}
+
+	log.Warningf("Backlog full (%d packets), link reading is blocked", cap(outChan))
+	select {
+	case outChan <- packet:
+		return true
+	case <-quit:
+		return false
+	}
+}
@@ -0,0 +1,78 @@
+package uavtalk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBacklogPolicy(t *testing.T) {
+	loadTestDefinitions(t)
+	defer func() { OutBacklogPolicy = BlockOnFullBacklog }()
+	packet := *NewPacket(AllDefinitions.MustGetDefinitionForName("AttitudeActual"), ObjectCmd, 0, attitudeData())
+
+	// the consumer is slow, the backlog is already full
+	OutBacklogPolicy = DropOnFullBacklog
+	outChan := make(chan Packet, 2)
+	setBacklogs(nil, outChan)
+	dropped := DroppedPackets()
+	for i := 0; i < 5; i++ {
+		pushOut(outChan, packet, nil)
+	}
+	if BacklogDepth() != 2 || DroppedPackets()-dropped != 3 {
+		t.Errorf("drop policy: backlog depth %d, %d dropped, expected 2 and 3", BacklogDepth(), DroppedPackets()-dropped)
+	}
+
+	OutBacklogPolicy = BlockOnFullBacklog
+	pushed := make(chan struct{})
+	go func() {
+		pushOut(outChan, packet, nil)
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("block policy: pushed to a full backlog")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-outChan
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("block policy: still blocked once the consumer read a packet")
+	}
+	if BacklogDepth() != 2 || DroppedPackets()-dropped != 3 {
+		t.Errorf("block policy: backlog depth %d, %d dropped, expected 2 and 3", BacklogDepth(), DroppedPackets()-dropped)
+	}
+}
+
+func TestInBacklogDepth(t *testing.T) {
+	loadTestDefinitions(t)
+	defer setBacklogs(nil, nil)
+	packet := *NewPacket(AllDefinitions.MustGetDefinitionForName("AttitudeActual"), ObjectRequest, 0, nil)
+
+	setBacklogs(nil, nil)
+	if InBacklogDepth() != 0 || BacklogDepth() != 0 {
+		t.Errorf("depths %d and %d before Start", InBacklogDepth(), BacklogDepth())
+	}
+
+	// filled partway, while read from another goroutine
+	inChan := make(chan Packet, 8)
+	setBacklogs(inChan, make(chan Packet, 8))
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			InBacklogDepth()
+		}
+		close(done)
+	}()
+	for i := 0; i < 5; i++ {
+		inChan <- packet
+	}
+	<-done
+	if InBacklogDepth() != 5 || BacklogDepth() != 0 {
+		t.Errorf("depths %d and %d, expected 5 and 0", InBacklogDepth(), BacklogDepth())
+	}
+	<-inChan
+	if InBacklogDepth() != 4 {
+		t.Errorf("depth %d once a packet is sent, expected 4", InBacklogDepth())
+	}
+}
@@ -0,0 +1,145 @@
+package uavtalk
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// batch is written on the link in one go, its packets are encoded by the writer goroutine
+// with the checksum of the link, after the frames given as is
+type batch struct {
+	packets []Packet
+	frames  [][]byte
+	result  chan error
+}
+
+var batchChan = make(chan batch)
+
+// encode appends the frames of the packets of the batch, nothing is appended if one of them can't be encoded
+func (b *batch) encode(checksum Checksum) error {
+	frames := make([][]byte, 0, len(b.packets))
+	for i := range b.packets {
+		frame, err := encodeForLink(&b.packets[i], checksum)
+		if err != nil {
+			return err
+		}
+		frames = append(frames, frame)
+	}
+	b.frames = append(b.frames, frames...)
+	return nil
+}
+
+func (b batch) writeTo(writer io.Writer) error {
+	for _, frame := range b.frames {
+		if _, err := writer.Write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchAckTimeout is how long SendBatch waits for the acks of its ObjectCmdWithAck packets once written
+var BatchAckTimeout = 2 * time.Second
+
+// batchWaiter is a SendBatch waiting for the ack of one of its packets
+type batchWaiter struct {
+	objectID   uint32
+	instanceID uint16
+	reply      chan Packet
+}
+
+var batchWaiters struct {
+	sync.Mutex
+	waiters []*batchWaiter
+}
+
+// replyBatchAck passes an ack or nack read from the link to the first SendBatch waiting for it
+func replyBatchAck(packet Packet) {
+	batchWaiters.Lock()
+	defer batchWaiters.Unlock()
+	for _, waiter := range batchWaiters.waiters {
+		if waiter.objectID != packet.ObjectID || waiter.instanceID != packet.InstanceID {
+			continue
+		}
+		select {
+		case waiter.reply <- packet:
+			return
+		default:
+			// already acked, the same instance is sent twice in the batch
+		}
+	}
+}
+
+func addBatchWaiters(packets []Packet) []*batchWaiter {
+	var waiters []*batchWaiter
+	for _, packet := range packets {
+		if packet.Cmd == ObjectCmdWithAck {
+			waiters = append(waiters, &batchWaiter{packet.ObjectID, packet.InstanceID, make(chan Packet, 1)})
+		}
+	}
+	batchWaiters.Lock()
+	defer batchWaiters.Unlock()
+	batchWaiters.waiters = append(batchWaiters.waiters, waiters...)
+	return waiters
+}
+
+func removeBatchWaiters(waiters []*batchWaiter) {
+	batchWaiters.Lock()
+	defer batchWaiters.Unlock()
+	kept := batchWaiters.waiters[:0]
+	for _, waiter := range batchWaiters.waiters {
+		removed := false
+		for _, w := range waiters {
+			if w == waiter {
+				removed = true
+				break
+			}
+		}
+		if removed == false {
+			kept = append(kept, waiter)
+		}
+	}
+	batchWaiters.waiters = kept
+}
+
+// SendBatch writes packets in order on the link, without any packet from inChan in between.
+// Nothing is written if one of the packets can't be encoded, otherwise the first write error is returned.
+// Once written, the acks of the ObjectCmdWithAck packets are collected for at most BatchAckTimeout,
+// an error is returned for the first nack or missing ack. The acks are still received on outChan as usual.
+// It blocks until the batch has been written and acked, Start has to be running.
+func SendBatch(packets []Packet) error {
+	// waiting before writing, the acks can be read before the write returns
+	waiters := addBatchWaiters(packets)
+	defer removeBatchWaiters(waiters)
+
+	result := make(chan error, 1)
+	batchChan <- batch{packets: packets, result: result}
+	if err := <-result; err != nil {
+		return err
+	}
+
+	timer := DefaultClock.NewTimer(BatchAckTimeout)
+	defer timer.Stop()
+	for _, waiter := range waiters {
+		select {
+		case reply := <-waiter.reply:
+			if reply.Cmd == ObjectNack {
+				return fmt.Errorf("Object %#x instance %d of the batch nacked by the flight controller", waiter.objectID, waiter.instanceID)
+			}
+		case <-timer.C():
+			return fmt.Errorf("No ack for object %#x instance %d of the batch after %s", waiter.objectID, waiter.instanceID, BatchAckTimeout)
+		}
+	}
+	return nil
+}
+
+// SendRaw writes already encoded frames on the link as they are (the usb link still splits them in HID reports),
+// in order and without any packet from inChan in between, which could otherwise be cut in half.
+// It blocks until the frames have been written, Start has to be running.
+func SendRaw(frames ...[]byte) error {
+	result := make(chan error, 1)
+	batchChan <- batch{frames: frames, result: result}
+	return <-result
+}
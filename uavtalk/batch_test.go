@@ -0,0 +1,88 @@
+package uavtalk
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBatchEncode(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Label")
+
+	raw := []byte{0x3c}
+	b := batch{
+		packets: []Packet{*NewPacket(definition, ObjectCmd, 0, labelData()), *NewPacket(definition, ObjectRequest, 0, nil)},
+		frames:  [][]byte{raw},
+	}
+	if err := b.encode(CRC16); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.frames) != 3 || bytes.Equal(b.frames[0], raw) == false {
+		t.Fatalf("%d frames", len(b.frames))
+	}
+	for i, packet := range b.packets {
+		frame := b.frames[i+1]
+		if len(frame) != frameLength(definition, packet.Cmd)+CRC16.Size() {
+			t.Errorf("%d: %d bytes", i, len(frame))
+		}
+		if _, err := newPacketFromBinary(frame, CRC16.Size()); err != nil {
+			t.Errorf("%d: %s", i, err)
+		}
+	}
+
+	// nothing is added when a packet can't be encoded
+	b = batch{packets: []Packet{*NewPacket(definition, ObjectCmd, 0, labelData()), *NewPacket(definition, ObjectCmd, 0, map[string]interface{}{})}}
+	if err := b.encode(CRC8); err == nil || len(b.frames) != 0 {
+		t.Errorf("got %v, %d frames", err, len(b.frames))
+	}
+}
+
+func TestSendBatchAcks(t *testing.T) {
+	loadTestDefinitions(t)
+	clock := newManualClock()
+	DefaultClock = clock
+	defer func() { DefaultClock = systemClock{} }()
+	label := AllDefinitions.MustGetDefinitionForName("Label")
+	waypoint := AllDefinitions.MustGetDefinitionForName("Waypoint")
+	packets := []Packet{
+		*NewPacket(label, ObjectCmdWithAck, 0, labelData()),
+		*NewPacket(waypoint, ObjectCmd, 1, waypointData()),
+		*NewPacket(waypoint, ObjectCmdWithAck, 2, waypointData()),
+	}
+
+	tests := []struct {
+		replies []*Packet
+		timeout bool
+		ok      bool
+	}{
+		{[]*Packet{NewPacket(waypoint, ObjectAck, 2, nil), NewPacket(label, ObjectAck, 0, nil)}, false, true},
+		// the ack of another instance doesn't count
+		{[]*Packet{NewPacket(label, ObjectAck, 0, nil), NewPacket(waypoint, ObjectAck, 1, nil)}, true, false},
+		{[]*Packet{NewPacket(label, ObjectAck, 0, nil), NewPacket(waypoint, ObjectNack, 2, nil)}, false, false},
+		{nil, true, false},
+	}
+	for i, test := range tests {
+		result := make(chan error, 1)
+		go func() { result <- SendBatch(packets) }()
+
+		// the writer goroutine of the link, the board acking once the batch is written
+		b := <-batchChan
+		if err := b.encode(CRC8); err != nil || len(b.frames) != len(packets) {
+			t.Fatalf("case %d: %d frames encoded, %v", i, len(b.frames), err)
+		}
+		b.result <- nil
+		for _, reply := range test.replies {
+			replyBatchAck(*reply)
+		}
+		if test.timeout {
+			clock.waitTimers(t, 1)
+			clock.Advance(BatchAckTimeout)
+		}
+		if err := <-result; (err == nil) != test.ok {
+			t.Errorf("case %d: got %v", i, err)
+		}
+	}
+	if len(batchWaiters.waiters) != 0 {
+		t.Errorf("%d waiters left", len(batchWaiters.waiters))
+	}
+}
@@ -0,0 +1,156 @@
+package uavtalk
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// InstanceData is the data of an object instance
+type InstanceData struct {
+	InstanceID uint16
+	Data       map[string]interface{}
+}
+
+type instanceDataSlice []InstanceData
+
+func (s instanceDataSlice) Len() int           { return len(s) }
+func (s instanceDataSlice) Less(i, j int) bool { return s[i].InstanceID < s[j].InstanceID }
+func (s instanceDataSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// PacketCache keeps the last data received for each object instance,
+// the cached maps are the packets' ones and must not be modified.
+type PacketCache struct {
+	lock   sync.RWMutex
+	values map[uint32]map[uint16]map[string]interface{}
+	// the data replaced by the last update of each instance, for DiffLatest
+	previous map[uint32]map[uint16]map[string]interface{}
+}
+
+// NewPacketCache returns an empty PacketCache
+func NewPacketCache() *PacketCache {
+	return &PacketCache{
+		values:   make(map[uint32]map[uint16]map[string]interface{}),
+		previous: make(map[uint32]map[uint16]map[string]interface{}),
+	}
+}
+
+// LastValues is filled with the packets received from the flight controller
+var LastValues = NewPacketCache()
+
+// Update stores the data of packet if it carries any, packets of unknown objects are ignored
+func (cache *PacketCache) Update(packet *Packet) {
+	if packet.Definition == nil || (packet.Cmd != ObjectCmd && packet.Cmd != ObjectCmdWithAck) {
+		return
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	instances, ok := cache.values[packet.Definition.ObjectID]
+	if ok == false {
+		instances = make(map[uint16]map[string]interface{})
+		cache.values[packet.Definition.ObjectID] = instances
+	}
+	if data, ok := instances[packet.InstanceID]; ok {
+		previous, ok := cache.previous[packet.Definition.ObjectID]
+		if ok == false {
+			previous = make(map[uint16]map[string]interface{})
+			cache.previous[packet.Definition.ObjectID] = previous
+		}
+		previous[packet.InstanceID] = data
+	}
+	instances[packet.InstanceID] = packet.Data
+}
+
+// Get returns the last data received for an object instance
+func (cache *PacketCache) Get(objectID uint32, instanceID uint16) (map[string]interface{}, bool) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	data, ok := cache.values[objectID][instanceID]
+	return data, ok
+}
+
+// GetAllInstances returns the last data of all the instances received for an object, ordered by InstanceID,
+// the slice is empty if nothing was received for the object.
+func (cache *PacketCache) GetAllInstances(objectID uint32) []InstanceData {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	instances := cache.values[objectID]
+	result := make(instanceDataSlice, 0, len(instances))
+	for instanceID, data := range instances {
+		result = append(result, InstanceData{instanceID, data})
+	}
+	sort.Sort(result)
+	return result
+}
+
+// FieldChange is a field element that changed between two updates of an object instance
+type FieldChange struct {
+	Field string
+	// Element is the element name or the index of an array field, empty for single element fields
+	Element string
+	Old     interface{}
+	New     interface{}
+}
+
+// DiffLatest returns the field elements changed by the last update of an object instance, ordered by field name
+// then element. It returns false until two updates were received for the instance.
+func (cache *PacketCache) DiffLatest(objectID uint32, instanceID uint16) ([]FieldChange, bool) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+
+	old, ok := cache.previous[objectID][instanceID]
+	if ok == false {
+		return nil, false
+	}
+	latest := cache.values[objectID][instanceID]
+
+	names := make([]string, 0, len(latest))
+	for name := range latest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	changes := []FieldChange{}
+	for _, name := range names {
+		changes = appendChanges(changes, name, old[name], latest[name])
+	}
+	return changes, true
+}
+
+// appendChanges appends the elements differing between two decoded values of a field
+func appendChanges(changes []FieldChange, field string, old interface{}, latest interface{}) []FieldChange {
+	switch value := latest.(type) {
+	case []interface{}:
+		oldArray, _ := old.([]interface{})
+		for i, element := range value {
+			var oldElement interface{}
+			if i < len(oldArray) {
+				oldElement = oldArray[i]
+			}
+			if oldElement != element {
+				changes = append(changes, FieldChange{field, strconv.Itoa(i), oldElement, element})
+			}
+		}
+	case map[string]interface{}:
+		oldMap, _ := old.(map[string]interface{})
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if oldMap[name] != value[name] {
+				changes = append(changes, FieldChange{field, name, oldMap[name], value[name]})
+			}
+		}
+	default:
+		if old != latest {
+			changes = append(changes, FieldChange{field, "", old, latest})
+		}
+	}
+	return changes
+}
@@ -0,0 +1,87 @@
+package uavtalk
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPacketCache(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+	cache := NewPacketCache()
+
+	update := func(cmd uint8, instanceID uint16, data map[string]interface{}) {
+		cache.Update(NewPacket(definition, cmd, instanceID, data))
+	}
+	first := map[string]interface{}{
+		"Velocity": float32(1),
+		"Position": map[string]interface{}{"North": float32(0), "East": float32(0), "Down": float32(0)},
+		"Counter":  []interface{}{int16(1), int16(2)},
+	}
+	second := map[string]interface{}{
+		"Velocity": float32(2),
+		"Position": map[string]interface{}{"North": float32(0), "East": float32(5), "Down": float32(0)},
+		"Counter":  []interface{}{int16(1), int16(3)},
+	}
+
+	update(ObjectCmd, 2, first)
+	update(ObjectCmdWithAck, 0, first)
+	// requests and acks carry no data
+	update(ObjectRequest, 1, map[string]interface{}{})
+	update(ObjectAck, 2, map[string]interface{}{})
+	// unknown objects passed through are not cached
+	cache.Update(&Packet{ObjectID: definition.ObjectID, Cmd: ObjectCmd, InstanceID: 3, Data: second})
+
+	if data, ok := cache.Get(definition.ObjectID, 2); ok == false || reflect.DeepEqual(data, first) == false {
+		t.Errorf("instance 2: got %v %t", data, ok)
+	}
+	if _, ok := cache.Get(definition.ObjectID, 1); ok {
+		t.Error("instance 1 cached from a request")
+	}
+	instances := cache.GetAllInstances(definition.ObjectID)
+	if len(instances) != 2 || instances[0].InstanceID != 0 || instances[1].InstanceID != 2 {
+		t.Errorf("got instances %v", instances)
+	}
+	if instances := cache.GetAllInstances(definition.Meta.ObjectID); len(instances) != 0 {
+		t.Errorf("got instances %v for an object never received", instances)
+	}
+}
+
+func TestDiffLatest(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+	cache := NewPacketCache()
+
+	update := func(instanceID uint16, data map[string]interface{}) {
+		cache.Update(NewPacket(definition, ObjectCmd, instanceID, data))
+	}
+	first := map[string]interface{}{
+		"Velocity": float32(1),
+		"Position": map[string]interface{}{"North": float32(0), "East": float32(0), "Down": float32(0)},
+		"Counter":  []interface{}{int16(1), int16(2)},
+	}
+	second := map[string]interface{}{
+		"Velocity": float32(2),
+		"Position": map[string]interface{}{"North": float32(0), "East": float32(5), "Down": float32(0)},
+		"Counter":  []interface{}{int16(1), int16(3)},
+	}
+
+	update(2, first)
+	if _, ok := cache.DiffLatest(definition.ObjectID, 2); ok {
+		t.Error("diff after a single update")
+	}
+	update(2, second)
+	changes, ok := cache.DiffLatest(definition.ObjectID, 2)
+	expected := []FieldChange{
+		{"Counter", "1", int16(2), int16(3)},
+		{"Position", "East", float32(0), float32(5)},
+		{"Velocity", "", float32(1), float32(2)},
+	}
+	if ok == false || reflect.DeepEqual(changes, expected) == false {
+		t.Errorf("got changes %v, expected %v", changes, expected)
+	}
+	update(2, second)
+	if changes, ok := cache.DiffLatest(definition.ObjectID, 2); ok == false || len(changes) != 0 {
+		t.Errorf("got changes %v for the same data", changes)
+	}
+}
@@ -0,0 +1,69 @@
+package uavtalk
+
+/**
+ * UAVTalk frames end with a CRC-8, some transports wrapping the frames use a stronger checksum instead.
+ * A Linker implementing ChecksumLinker sets the checksum used while it is open, for that link only.
+ */
+
+// Checksum computes the bytes appended to a frame to check its integrity
+type Checksum interface {
+	// Size is the number of bytes of the checksum
+	Size() int
+	// Sum returns the checksum of frame
+	Sum(frame []byte) []byte
+}
+
+// ChecksumLinker is implemented by links whose frames don't end with the UAVTalk CRC-8
+type ChecksumLinker interface {
+	Linker
+	Checksum() Checksum
+}
+
+// TrustedLinker is implemented by links reliable enough, like a local tcp link to a simulator,
+// for the checksum of received frames not to be verified. The checksum bytes are still expected.
+type TrustedLinker interface {
+	Linker
+	SkipChecksum() bool
+}
+
+// CRC8 is the UAVTalk checksum
+var CRC8 Checksum = crc8Checksum{}
+
+// CRC16 is the CRC-16/CCITT-FALSE checksum, written little endian
+var CRC16 Checksum = crc16Checksum{}
+
+// FrameChecksum is the checksum of the frames encoded and decoded outside of a link:
+// StreamEncoder, StreamDecoder, CachedEncoder and FormatFrame take it when created or called.
+// The link never changes it, set it before use if needed.
+var FrameChecksum = CRC8
+
+type crc8Checksum struct{}
+
+func (crc8Checksum) Size() int {
+	return 1
+}
+
+func (crc8Checksum) Sum(frame []byte) []byte {
+	return []byte{computeCrc8(0, frame)}
+}
+
+type crc16Checksum struct{}
+
+func (crc16Checksum) Size() int {
+	return 2
+}
+
+func (crc16Checksum) Sum(frame []byte) []byte {
+	crc := uint16(0xffff)
+	for _, b := range frame {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return []byte{byte(crc), byte(crc >> 8)}
+}
@@ -0,0 +1,489 @@
+package uavtalk
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+/**
+ * Client hides the plumbing needed to talk to a flight controller: the link started by Start,
+ * the GCS telemetry handshake, acking the objects sent with ObjectCmdWithAck and matching replies to requests.
+ * Start and the definitions are package wide, so there is only one Client per process.
+ */
+
+// Client is a connection to the flight controller, see NewClient
+type Client struct {
+	// HandshakeTimeout is how long the handshake can take before being reported as failed and started again
+	HandshakeTimeout time.Duration
+	// SnapshotOnSubscribe makes Subscribe pass the cached instances of the object (see LastValues) to the OnUpdate callbacks
+	SnapshotOnSubscribe bool
+	// Clock times the handshake and the timeouts, DefaultClock unless replaced before Connect
+	Clock Clock
+
+	inChan  chan Packet
+	outChan chan Packet
+	// start runs the link between the channels, Start unless replaced by a test
+	start func(inChan chan Packet, outChan chan Packet)
+	// objects whose snapshot dispatch has to pass to the callbacks, see Subscribe.
+	// snapshotWake tells dispatch some are pending, it is never blocked on.
+	snapshotWake chan struct{}
+
+	lock          sync.Mutex
+	snapshots     []*Definition
+	connected     bool
+	connectedChan chan struct{}
+	subscriptions map[uint32]bool
+	callbacks     []func(Packet)
+	waiters       []*objectWaiter
+
+	// current handshake attempt, and the reason the last one failed
+	handshakeStarted time.Time
+	handshakeStatus  string
+	handshakeErr     error
+}
+
+// objectWaiter is a GetObject waiting for the data of an object instance, or a CreateInstance waiting for its ack
+type objectWaiter struct {
+	objectID   uint32
+	instanceID uint16
+	ack        bool
+	// accept filters the packets replied, all are when nil
+	accept func(Packet) bool
+	reply  chan Packet
+}
+
+var errNotConnected = errors.New("Not connected to the flight controller")
+
+// DefaultHandshakeTimeout is the HandshakeTimeout of the clients returned by NewClient
+var DefaultHandshakeTimeout = 5 * time.Second
+
+// NewClient returns a Client, definitions have to be loaded before calling Connect
+func NewClient() *Client {
+	return &Client{
+		HandshakeTimeout: DefaultHandshakeTimeout,
+		Clock:            DefaultClock,
+		start:            Start,
+		inChan:           make(chan Packet, 100),
+		outChan:          make(chan Packet, 100),
+		snapshotWake:     make(chan struct{}, 1),
+		connectedChan:    make(chan struct{}),
+		subscriptions:    make(map[uint32]bool),
+	}
+}
+
+// Connect starts the link and waits for the telemetry handshake to be done, for at most timeout.
+// It can only be called once, the link is then kept open, and the handshake redone, for the life of the process.
+func (client *Client) Connect(timeout time.Duration) error {
+	for _, name := range []string{"GCSTelemetryStats", "FlightTelemetryStats"} {
+		if _, err := AllDefinitions.GetDefinitionForName(name); err != nil {
+			return fmt.Errorf("Can't connect without the %s definition: %s", name, err)
+		}
+	}
+
+	go client.start(client.inChan, client.outChan)
+	go client.dispatch()
+	client.startHandshake()
+	go client.watchHandshake()
+
+	return client.WaitConnected(timeout)
+}
+
+// WaitConnected waits for at most timeout for the telemetry handshake to be done, it returns at once when it already is
+func (client *Client) WaitConnected(timeout time.Duration) error {
+	client.lock.Lock()
+	connected := client.connectedChan
+	done := client.connected
+	client.lock.Unlock()
+	if done {
+		return nil
+	}
+
+	timer := client.Clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-connected:
+		return nil
+	case <-timer.C():
+		return fmt.Errorf("Handshake not done after %s", timeout)
+	}
+}
+
+// HandshakeError returns why the last handshake attempt failed, nil once the handshake is done.
+// A failed handshake is started again, unlike a link down this usually means the board is in its bootloader or too busy to answer.
+func (client *Client) HandshakeError() error {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	return client.handshakeErr
+}
+
+// Connected tells whether the telemetry handshake is done
+func (client *Client) Connected() bool {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	return client.connected
+}
+
+// Subscribe makes the updates of the object with the given name reach the OnUpdate callbacks,
+// preceded by the instances already received when SnapshotOnSubscribe is set.
+// It never blocks, and can be called from a callback.
+func (client *Client) Subscribe(name string) error {
+	definition, err := AllDefinitions.GetDefinitionForName(name)
+	if err != nil {
+		return err
+	}
+
+	client.lock.Lock()
+	client.subscriptions[definition.ObjectID] = true
+	if client.SnapshotOnSubscribe {
+		client.snapshots = append(client.snapshots, definition)
+	}
+	client.lock.Unlock()
+
+	if client.SnapshotOnSubscribe {
+		select {
+		case client.snapshotWake <- struct{}{}:
+		default:
+			// already woken, the snapshot is taken along
+		}
+	}
+	return nil
+}
+
+// OnUpdate adds a callback receiving the updates of the subscribed objects,
+// callbacks are called in turn from a single goroutine and must not block.
+func (client *Client) OnUpdate(callback func(Packet)) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	client.callbacks = append(client.callbacks, callback)
+}
+
+// Send sets the object with the given name, see SendInstance
+func (client *Client) Send(name string, data map[string]interface{}) error {
+	return client.SendInstance(name, 0, data)
+}
+
+// SendInstance sets an instance of the object with the given name, see NewUpdatePacket
+func (client *Client) SendInstance(name string, instanceID uint16, data map[string]interface{}) error {
+	if client.Connected() == false {
+		return errNotConnected
+	}
+	packet, err := NewUpdatePacket(name, instanceID, data)
+	if err != nil {
+		return err
+	}
+	client.inChan <- *packet
+	return nil
+}
+
+// GetObject requests an instance of the object with the given name and waits for at most timeout for its data,
+// a nack from the board is returned as an error.
+func (client *Client) GetObject(name string, instanceID uint16, timeout time.Duration) (map[string]interface{}, error) {
+	if client.Connected() == false {
+		return nil, errNotConnected
+	}
+	request, err := NewRequestPacket(name, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	definition := request.Definition
+
+	waiter := client.addWaiter(definition.ObjectID, instanceID, false, nil)
+	defer client.removeWaiter(waiter)
+
+	client.inChan <- *request
+
+	timer := client.Clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case packet := <-waiter.reply:
+		if packet.Cmd == ObjectNack {
+			return nil, fmt.Errorf("%s instance %d not found, nack from the flight controller", definition.Name, instanceID)
+		}
+		return packet.Data, nil
+	case <-timer.C():
+		return nil, fmt.Errorf("No reply for %s after %s", definition.Name, timeout)
+	}
+}
+
+// CreateInstance creates an instance of the multi instance object with the given name, the board nacking requests
+// for the instances it doesn't have. The instance is sent with ObjectCmdWithAck and its ack waited for at most timeout.
+func (client *Client) CreateInstance(name string, instanceID uint16, data map[string]interface{}, timeout time.Duration) error {
+	if client.Connected() == false {
+		return errNotConnected
+	}
+	packet, err := NewUpdatePacket(name, instanceID, data)
+	if err != nil {
+		return err
+	}
+	definition := packet.Definition
+	if definition.SingleInstance {
+		return fmt.Errorf("%s is a single instance object, it has no instance to create", definition.Name)
+	}
+	packet.Cmd = ObjectCmdWithAck
+
+	waiter := client.addWaiter(definition.ObjectID, instanceID, true, nil)
+	defer client.removeWaiter(waiter)
+
+	client.inChan <- *packet
+
+	timer := client.Clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-waiter.reply:
+		if reply.Cmd == ObjectNack {
+			return fmt.Errorf("%s instance %d not created, nack from the flight controller", definition.Name, instanceID)
+		}
+		return nil
+	case <-timer.C():
+		return fmt.Errorf("No ack for %s instance %d after %s", definition.Name, instanceID, timeout)
+	}
+}
+
+// SaveSettings saves an instance of the object with the given name to the board flash, and waits for at most timeout
+// for the board to report the save done, by updating ObjectPersistence with the Completed or Error operation.
+func (client *Client) SaveSettings(name string, instanceID uint16, timeout time.Duration) error {
+	if client.Connected() == false {
+		return errNotConnected
+	}
+	definition, err := AllDefinitions.GetDefinitionForName(name)
+	if err != nil {
+		return err
+	}
+	if err := checkInstanceID(definition, instanceID); err != nil {
+		return err
+	}
+	objectPersistence, err := AllDefinitions.GetDefinitionForName("ObjectPersistence")
+	if err != nil {
+		return err
+	}
+
+	// only the end of this save is replied, the waiter holding a single packet
+	waiter := client.addWaiter(objectPersistence.ObjectID, 0, false, func(packet Packet) bool {
+		if packet.Cmd == ObjectNack {
+			return true
+		}
+		if packet.Data["ObjectID"] != definition.ObjectID || packet.Data["InstanceID"] != uint32(instanceID) {
+			return false
+		}
+		operation := packet.Data["Operation"]
+		return operation == "Completed" || operation == "Error"
+	})
+	defer client.removeWaiter(waiter)
+
+	client.inChan <- CreateObjectPersistencePacket(PersistenceSave, PersistenceSingleObject, definition.ObjectID, instanceID)
+
+	timer := client.Clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case packet := <-waiter.reply:
+		if packet.Cmd == ObjectNack {
+			return fmt.Errorf("Saving %s instance %d: ObjectPersistence nack from the flight controller", definition.Name, instanceID)
+		}
+		if packet.Data["Operation"] == "Error" {
+			return fmt.Errorf("Saving %s instance %d failed on the flight controller", definition.Name, instanceID)
+		}
+		return nil
+	case <-timer.C():
+		return fmt.Errorf("%s instance %d not reported saved after %s", definition.Name, instanceID, timeout)
+	}
+}
+
+func (client *Client) addWaiter(objectID uint32, instanceID uint16, ack bool, accept func(Packet) bool) *objectWaiter {
+	waiter := &objectWaiter{objectID, instanceID, ack, accept, make(chan Packet, 1)}
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	client.waiters = append(client.waiters, waiter)
+	return waiter
+}
+
+func (client *Client) removeWaiter(waiter *objectWaiter) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	for i, w := range client.waiters {
+		if w == waiter {
+			client.waiters = append(client.waiters[:i], client.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatch handles everything received from the flight controller
+func (client *Client) dispatch() {
+	for {
+		select {
+		case packet, ok := <-client.outChan:
+			if ok == false {
+				return
+			}
+			client.handle(packet)
+		case <-client.snapshotWake:
+			client.sendSnapshots()
+		}
+	}
+}
+
+// sendSnapshots passes the cached instances of the objects pending a snapshot to the callbacks.
+// LastValues is read here, between the packets dispatched, so a snapshot is never older than the updates passed before it.
+func (client *Client) sendSnapshots() {
+	client.lock.Lock()
+	definitions := client.snapshots
+	client.snapshots = nil
+	client.lock.Unlock()
+
+	for _, definition := range definitions {
+		for _, instance := range LastValues.GetAllInstances(definition.ObjectID) {
+			// only for the callbacks, the board didn't send it
+			client.notify(*NewPacket(definition, ObjectCmd, instance.InstanceID, instance.Data))
+		}
+	}
+}
+
+func (client *Client) handle(packet Packet) {
+	if packet.Definition == nil {
+		return
+	}
+	if packet.Definition.Name == "FlightTelemetryStats" {
+		client.handshake(packet)
+	}
+
+	if packet.Cmd == ObjectCmdWithAck {
+		client.ack(packet)
+	}
+	if packet.Cmd != ObjectCmd && packet.Cmd != ObjectCmdWithAck && packet.Cmd != ObjectAck && packet.Cmd != ObjectNack {
+		return
+	}
+
+	client.lock.Lock()
+	// a nack means the board doesn't have the requested object
+	client.reply(packet)
+	client.lock.Unlock()
+	if packet.Cmd == ObjectNack || packet.Cmd == ObjectAck {
+		return
+	}
+	client.notify(packet)
+}
+
+// ack acks an object received with ObjectCmdWithAck. It never blocks dispatch: when inChan is full,
+// as while sending is paused, the ack is dropped and the board sends the object again.
+func (client *Client) ack(packet Packet) {
+	select {
+	case client.inChan <- CreatePacketAck(packet.Definition, packet.InstanceID):
+	default:
+		log.Warningf("Backlog full, %s instance %d not acked", packet.Definition.Name, packet.InstanceID)
+	}
+}
+
+// notify passes packet to the OnUpdate callbacks when its object is subscribed
+func (client *Client) notify(packet Packet) {
+	if packet.Definition == nil {
+		return
+	}
+	client.lock.Lock()
+	var callbacks []func(Packet)
+	if client.subscriptions[packet.Definition.ObjectID] {
+		callbacks = client.callbacks
+	}
+	client.lock.Unlock()
+
+	for _, callback := range callbacks {
+		callback(packet)
+	}
+}
+
+// reply passes packet to the GetObject or CreateInstance calls waiting for its object instance, client.lock has to be held
+func (client *Client) reply(packet Packet) {
+	for _, waiter := range client.waiters {
+		if waiter.objectID != packet.Definition.ObjectID || waiter.instanceID != packet.InstanceID {
+			continue
+		}
+		// both are nacked, only CreateInstance waits for acks and only GetObject for data
+		if waiter.accept != nil && waiter.accept(packet) == false {
+			continue
+		}
+		if packet.Cmd == ObjectNack || (packet.Cmd == ObjectAck) == waiter.ack {
+			select {
+			case waiter.reply <- packet:
+			default:
+			}
+		}
+	}
+}
+
+// handshake follows the FlightTelemetryStats status, answering as the GCS does
+func (client *Client) handshake(packet Packet) {
+	if packet.Cmd != ObjectCmd && packet.Cmd != ObjectCmdWithAck {
+		return
+	}
+
+	status, _ := packet.Data["Status"].(string)
+	client.lock.Lock()
+	client.handshakeStatus = status
+	client.lock.Unlock()
+
+	switch status {
+	case "Disconnected":
+		if client.Connected() {
+			// the board lost the link, a new attempt starts
+			client.setConnected(false)
+			client.startHandshake()
+		} else {
+			client.inChan <- CreateGCSTelemetryStatsObjectPacket("HandshakeReq")
+		}
+	case "HandshakeAck":
+		client.inChan <- CreateGCSTelemetryStatsObjectPacket("Connected")
+	case "Connected":
+		client.setConnected(true)
+	}
+}
+
+// startHandshake sends the first handshake request of a new attempt
+func (client *Client) startHandshake() {
+	client.lock.Lock()
+	client.handshakeStarted = client.Clock.Now()
+	client.lock.Unlock()
+	client.inChan <- CreateGCSTelemetryStatsObjectPacket("HandshakeReq")
+}
+
+// watchHandshake reports the handshake attempts not done within HandshakeTimeout, and starts them again
+func (client *Client) watchHandshake() {
+	for {
+		client.lock.Lock()
+		wait := client.handshakeStarted.Add(client.HandshakeTimeout).Sub(client.Clock.Now())
+		if client.connected || wait > 0 {
+			client.lock.Unlock()
+			if wait < pollResolution {
+				wait = pollResolution
+			}
+			timer := client.Clock.NewTimer(wait)
+			<-timer.C()
+			continue
+		}
+		if client.handshakeStatus == "" {
+			client.handshakeErr = fmt.Errorf("Handshake failed, no FlightTelemetryStats received after %s", client.HandshakeTimeout)
+		} else {
+			client.handshakeErr = fmt.Errorf("Handshake failed, stuck at status %s after %s", client.handshakeStatus, client.HandshakeTimeout)
+		}
+		client.handshakeStatus = ""
+		err := client.handshakeErr
+		client.lock.Unlock()
+
+		log.Warning(err)
+		client.startHandshake()
+	}
+}
+
+func (client *Client) setConnected(connected bool) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	if connected && client.connected == false {
+		client.handshakeErr = nil
+		close(client.connectedChan)
+	} else if connected == false && client.connected {
+		client.connectedChan = make(chan struct{})
+	}
+	client.connected = connected
+}
@@ -0,0 +1,342 @@
+package uavtalk
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client driven by a manual clock, connected when connected is set, without link
+func newTestClient(connected bool) (*Client, *manualClock) {
+	clock := newManualClock()
+	client := NewClient()
+	client.Clock = clock
+	client.connected = connected
+	return client, clock
+}
+
+// mockController returns a start function answering the handshake of the client as a board does,
+// the other packets sent by the client are passed to sent
+func mockController(sent chan Packet, quit chan struct{}) func(inChan chan Packet, outChan chan Packet) {
+	return func(inChan chan Packet, outChan chan Packet) {
+		stats := AllDefinitions.MustGetDefinitionForName("FlightTelemetryStats")
+		for {
+			var packet Packet
+			select {
+			case <-quit:
+				return
+			case packet = <-inChan:
+			}
+			if packet.Definition.Name != "GCSTelemetryStats" {
+				sent <- packet
+				continue
+			}
+			switch packet.Data["Status"] {
+			case "HandshakeReq":
+				outChan <- *NewPacket(stats, ObjectCmd, 0, map[string]interface{}{"Status": "HandshakeAck"})
+			case "Connected":
+				outChan <- *NewPacket(stats, ObjectCmd, 0, map[string]interface{}{"Status": "Connected"})
+			}
+		}
+	}
+}
+
+func TestClientConnectSubscribe(t *testing.T) {
+	loadTestDefinitions(t)
+	waypoint := AllDefinitions.MustGetDefinitionForName("Waypoint")
+	client := NewClient()
+	sent := make(chan Packet, 4)
+	quit := make(chan struct{})
+	defer close(quit)
+	client.start = mockController(sent, quit)
+
+	if err := client.Connect(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan Packet, 4)
+	client.OnUpdate(func(packet Packet) { received <- packet })
+	if err := client.Subscribe("Waypoint"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the board sends an object not subscribed to, then an instance to ack
+	client.outChan <- *NewPacket(AllDefinitions.MustGetDefinitionForName("Label"), ObjectCmd, 0, labelData())
+	client.outChan <- *NewPacket(waypoint, ObjectCmdWithAck, 3, waypointData())
+	select {
+	case packet := <-received:
+		if packet.Definition != waypoint || packet.InstanceID != 3 {
+			t.Errorf("callback got %s instance %d", packet.Definition.Name, packet.InstanceID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("callback not called")
+	}
+	if len(received) != 0 {
+		t.Errorf("callback got %s, not subscribed to", (<-received).Definition.Name)
+	}
+	select {
+	case ack := <-sent:
+		if ack.Definition != waypoint || ack.Cmd != ObjectAck || ack.InstanceID != 3 {
+			t.Errorf("sent %s cmd %d instance %d", ack.Definition.Name, ack.Cmd, ack.InstanceID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not acked")
+	}
+}
+
+func TestClientTimeouts(t *testing.T) {
+	loadTestDefinitions(t)
+
+	client, clock := newTestClient(true)
+	result := make(chan error, 1)
+	go func() {
+		_, err := client.GetObject("Waypoint", 1, time.Second)
+		result <- err
+	}()
+	clock.waitTimers(t, 1)
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case err := <-result:
+		t.Fatalf("GetObject returned %v before its timeout", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(time.Millisecond)
+	if err := <-result; err == nil {
+		t.Error("GetObject didn't time out")
+	}
+
+	// replied before the timeout
+	go func() {
+		_, err := client.GetObject("Waypoint", 1, time.Second)
+		result <- err
+	}()
+	clock.waitTimers(t, 1)
+	client.handle(*NewPacket(AllDefinitions.MustGetDefinitionForName("Waypoint"), ObjectCmd, 1, map[string]interface{}{}))
+	if err := <-result; err != nil {
+		t.Error(err)
+	}
+
+	client, clock = newTestClient(false)
+	go func() {
+		result <- client.WaitConnected(time.Second)
+	}()
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Second)
+	if err := <-result; err == nil {
+		t.Error("WaitConnected didn't time out")
+	}
+}
+
+func TestClientHandshakeTimeout(t *testing.T) {
+	loadTestDefinitions(t)
+
+	client, clock := newTestClient(false)
+	client.startHandshake()
+	<-client.inChan
+	go client.watchHandshake()
+
+	clock.waitTimers(t, 1)
+	if err := client.HandshakeError(); err != nil {
+		t.Fatalf("handshake failed at once: %s", err)
+	}
+	clock.Advance(client.HandshakeTimeout)
+
+	// started again
+	request := <-client.inChan
+	if request.Definition.Name != "GCSTelemetryStats" || request.Data["Status"] != "HandshakeReq" {
+		t.Errorf("sent %s %v", request.Definition.Name, request.Data)
+	}
+	if err := client.HandshakeError(); err == nil {
+		t.Error("no handshake error after the timeout")
+	}
+}
+
+func TestClientSaveSettings(t *testing.T) {
+	loadTestDefinitions(t)
+	label := AllDefinitions.MustGetDefinitionForName("Label")
+	objectPersistence := AllDefinitions.MustGetDefinitionForName("ObjectPersistence")
+	persistence := func(operation string, objectID uint32) Packet {
+		return *NewPacket(objectPersistence, ObjectCmd, 0, map[string]interface{}{
+			"Operation": operation, "Selection": "SingleObject", "ObjectID": objectID, "InstanceID": uint32(0),
+		})
+	}
+
+	for _, operation := range []string{"Completed", "Error"} {
+		client, clock := newTestClient(true)
+		result := make(chan error, 1)
+		go func() {
+			result <- client.SaveSettings("Label", 0, time.Second)
+		}()
+		clock.waitTimers(t, 1)
+
+		// updates of the save in progress and of other saves, received before the one ending it
+		client.handle(persistence("Save", label.ObjectID))
+		client.handle(persistence("Completed", label.ObjectID+2))
+		client.handle(persistence("Save", label.ObjectID))
+		client.handle(persistence(operation, label.ObjectID))
+
+		if err := <-result; (err == nil) != (operation == "Completed") {
+			t.Errorf("%s: got %v", operation, err)
+		}
+	}
+}
+
+func TestClientSnapshots(t *testing.T) {
+	loadTestDefinitions(t)
+	defer func(values *PacketCache) { LastValues = values }(LastValues)
+	LastValues = NewPacketCache()
+	waypoint := AllDefinitions.MustGetDefinitionForName("Waypoint")
+	label := AllDefinitions.MustGetDefinitionForName("Label")
+
+	velocity := func(instanceID uint16, value float32) *Packet {
+		return NewPacket(waypoint, ObjectCmd, instanceID, map[string]interface{}{"Velocity": value})
+	}
+	for i := uint16(0); i < 200; i++ {
+		LastValues.Update(velocity(i, 1))
+	}
+	LastValues.Update(NewPacket(label, ObjectCmd, 0, map[string]interface{}{"Text": "quad450"}))
+
+	client, _ := newTestClient(true)
+	client.SnapshotOnSubscribe = true
+	received := make(chan Packet, 500)
+	client.OnUpdate(func(packet Packet) {
+		// subscribing from a callback doesn't block dispatch
+		if packet.Definition == waypoint && packet.InstanceID == 199 {
+			if err := client.Subscribe("Label"); err != nil {
+				t.Error(err)
+			}
+		}
+		received <- packet
+	})
+
+	// more instances than any channel holds, before dispatch runs
+	if err := client.Subscribe("Waypoint"); err != nil {
+		t.Fatal(err)
+	}
+	go client.dispatch()
+	defer close(client.outChan)
+
+	for i := 0; i < 200; i++ {
+		if packet := <-received; packet.Definition != waypoint || packet.Data["Velocity"] != float32(1) {
+			t.Fatalf("snapshot %d: got %s instance %d %v", i, packet.Definition.Name, packet.InstanceID, packet.Data)
+		}
+	}
+	if packet := <-received; packet.Definition != label || packet.Data["Text"] != "quad450" {
+		t.Fatalf("got %s %v instead of the Label snapshot", packet.Definition.Name, packet.Data)
+	}
+
+	// a snapshot taken while an update is queued is never older than it
+	client.lock.Lock()
+	delete(client.subscriptions, waypoint.ObjectID)
+	client.lock.Unlock()
+	LastValues = NewPacketCache()
+	LastValues.Update(velocity(0, 1))
+	if err := client.Subscribe("Waypoint"); err != nil {
+		t.Fatal(err)
+	}
+	LastValues.Update(velocity(0, 2))
+	client.outChan <- *velocity(0, 2)
+
+	var values []interface{}
+	for len(values) < 2 {
+		packet := <-received
+		values = append(values, packet.Data["Velocity"])
+	}
+	if values[1] != float32(2) {
+		t.Errorf("received velocities %v, the last one is stale", values)
+	}
+}
+
+func TestClientCreateInstance(t *testing.T) {
+	loadTestDefinitions(t)
+	waypoint := AllDefinitions.MustGetDefinitionForName("Waypoint")
+
+	if err := NewClient().CreateInstance("Waypoint", 3, waypointData(), time.Second); err != errNotConnected {
+		t.Errorf("created while not connected: %v", err)
+	}
+	client, _ := newTestClient(true)
+	if err := client.CreateInstance("Label", 0, labelData(), time.Second); err == nil {
+		t.Error("created an instance of a single instance object")
+	}
+
+	for _, cmd := range []uint8{ObjectAck, ObjectNack} {
+		client, clock := newTestClient(true)
+		result := make(chan error, 1)
+		go func() {
+			result <- client.CreateInstance("Waypoint", 3, waypointData(), time.Second)
+		}()
+		clock.waitTimers(t, 1)
+		if sent := <-client.inChan; sent.Definition != waypoint || sent.Cmd != ObjectCmdWithAck || sent.InstanceID != 3 {
+			t.Errorf("sent %s cmd %d instance %d", sent.Definition.Name, sent.Cmd, sent.InstanceID)
+		}
+
+		client.handle(*NewPacket(waypoint, cmd, 3, map[string]interface{}{}))
+		if err := <-result; (err == nil) != (cmd == ObjectAck) {
+			t.Errorf("cmd %d: got %v", cmd, err)
+		}
+	}
+
+	client, clock := newTestClient(true)
+	result := make(chan error, 1)
+	go func() {
+		result <- client.CreateInstance("Waypoint", 3, waypointData(), time.Second)
+	}()
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Second)
+	if err := <-result; err == nil {
+		t.Error("CreateInstance didn't time out")
+	}
+}
+
+func TestControllerPairRouting(t *testing.T) {
+	loadTestDefinitions(t)
+	waypoint := AllDefinitions.MustGetDefinitionForName("Waypoint")
+	label := AllDefinitions.MustGetDefinitionForName("Label")
+	pair := newControllerPair(t)
+	defer pair.Close()
+
+	received := make(chan Packet, 4)
+	pair.client.OnUpdate(func(packet Packet) { received <- packet })
+	for _, name := range []string{"Waypoint", "Label"} {
+		if err := pair.client.Subscribe(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// updates are routed in order to the subscribers, the other objects are not
+	pair.Send(*NewPacket(AllDefinitions.MustGetDefinitionForName("AttitudeActual"), ObjectCmd, 0, attitudeData()))
+	pair.Send(*NewPacket(waypoint, ObjectCmd, 1, waypointData()))
+	pair.Send(*NewPacket(label, ObjectCmd, 0, labelData()))
+	for _, expected := range []*Definition{waypoint, label} {
+		select {
+		case packet := <-received:
+			if packet.Definition != expected {
+				t.Errorf("callback got %s, expected %s", packet.Definition.Name, expected.Name)
+			}
+		case <-time.After(pairTimeout):
+			t.Fatalf("%s not routed", expected.Name)
+		}
+	}
+	if len(received) != 0 {
+		t.Errorf("callback got %s, not subscribed to", (<-received).Definition.Name)
+	}
+
+	if err := pair.client.SendInstance("Waypoint", 2, waypointData()); err != nil {
+		t.Fatal(err)
+	}
+	if packet := pair.Recv(); packet.Definition != waypoint || packet.Cmd != ObjectCmd || packet.InstanceID != 2 {
+		t.Errorf("controller got %s cmd %d instance %d", packet.Definition.Name, packet.Cmd, packet.InstanceID)
+	}
+
+	// a request is answered with the data of its instance
+	result := make(chan error, 1)
+	go func() {
+		_, err := pair.client.GetObject("Waypoint", 1, pairTimeout)
+		result <- err
+	}()
+	if request := pair.Recv(); request.Definition != waypoint || request.Cmd != ObjectRequest || request.InstanceID != 1 {
+		t.Fatalf("controller got %s cmd %d instance %d", request.Definition.Name, request.Cmd, request.InstanceID)
+	}
+	pair.Send(*NewPacket(waypoint, ObjectCmd, 1, waypointData()))
+	if err := <-result; err != nil {
+		t.Error(err)
+	}
+}
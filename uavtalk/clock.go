@@ -0,0 +1,41 @@
+package uavtalk
+
+import "time"
+
+// Clock gives the time to the time dependent parts of the package,
+// replacing DefaultClock allows to drive them from a fake clock.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of time.Timer used by the package
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// DefaultClock is the Clock used by the package, the system clock unless replaced
+var DefaultClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	timer *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t systemTimer) Stop() bool {
+	return t.timer.Stop()
+}
@@ -1,5 +1,6 @@
 package uavtalk
 
+// crc8Table is the precomputed UAVTalk CRC-8 (polynomial 0x07, no reflection) of every byte value
 var crc8Table = []byte{
 	0x00, 0x07, 0x0e, 0x09, 0x1c, 0x1b, 0x12, 0x15, 0x38, 0x3f, 0x36, 0x31, 0x24, 0x23, 0x2a, 0x2d,
 	0x70, 0x77, 0x7e, 0x79, 0x6c, 0x6b, 0x62, 0x65, 0x48, 0x4f, 0x46, 0x41, 0x54, 0x53, 0x5a, 0x5d,
@@ -19,6 +20,7 @@ var crc8Table = []byte{
 	0xde, 0xd9, 0xd0, 0xd7, 0xc2, 0xc5, 0xcc, 0xcb, 0xe6, 0xe1, 0xe8, 0xef, 0xfa, 0xfd, 0xf4, 0xf3,
 }
 
+// computeCrc8 continues crc8 over packet, a byte at a time through crc8Table
 func computeCrc8(crc8 uint8, packet []byte) uint8 {
 	for _, b := range packet {
 		crc8 = crc8Table[crc8^uint8(b)]
@@ -0,0 +1,69 @@
+package uavtalk
+
+import "testing"
+
+// bitwiseCrc8 computes the CRC-8 bit by bit, as crc8Table was generated
+func bitwiseCrc8(crc8 uint8, packet []byte) uint8 {
+	for _, b := range packet {
+		crc8 ^= b
+		for i := 0; i < 8; i++ {
+			if crc8&0x80 != 0 {
+				crc8 = crc8<<1 ^ 0x07
+			} else {
+				crc8 <<= 1
+			}
+		}
+	}
+	return crc8
+}
+
+func TestCrc8Table(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		if expected := bitwiseCrc8(0, []byte{byte(i)}); crc8Table[i] != expected {
+			t.Errorf("crc8Table[%#02x] is %#02x, expected %#02x", i, crc8Table[i], expected)
+		}
+	}
+}
+
+func TestCrc8(t *testing.T) {
+	long := make([]byte, 1000)
+	for i := range long {
+		long[i] = byte(i * 7)
+	}
+
+	tests := []struct {
+		crc8     uint8
+		packet   []byte
+		expected uint8
+	}{
+		{0, nil, 0x00},
+		// the CRC-8 check value
+		{0, []byte("123456789"), 0xf4},
+		// continued from a previous value
+		{0xf4, []byte{0x00}, crc8Table[0xf4]},
+		{0, long, bitwiseCrc8(0, long)},
+	}
+
+	for i, test := range tests {
+		if crc8 := computeCrc8(test.crc8, test.packet); crc8 != test.expected {
+			t.Errorf("case %d: got %#02x, expected %#02x", i, crc8, test.expected)
+		}
+	}
+
+	// computed in several steps as in one
+	if crc8 := computeCrc8(computeCrc8(0, long[:333]), long[333:]); crc8 != computeCrc8(0, long) {
+		t.Errorf("split computation got %#02x", crc8)
+	}
+}
+
+func BenchmarkCrc8(b *testing.B) {
+	frame := make([]byte, MaxHIDFrameSize)
+	for i := range frame {
+		frame[i] = byte(i)
+	}
+	b.SetBytes(int64(len(frame)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		computeCrc8(0, frame)
+	}
+}
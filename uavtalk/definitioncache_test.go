@@ -0,0 +1,69 @@
+package uavtalk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefinitionCache(t *testing.T) {
+	defer func() { ObjectIDHashVersion, ObjectIDHasher = OpenPilotHash, GCSObjectIDHash }()
+
+	first := testDefinitions(t)
+	second := testDefinitions(t)
+	for i := range first {
+		if first[i] == second[i] || first[i].ObjectID != second[i].ObjectID {
+			t.Errorf("%s: loaded twice as %p id %d and %p id %d", first[i].Name, first[i], first[i].ObjectID, second[i], second[i].ObjectID)
+		}
+		// loading again doesn't pair the first definitions with other metas
+		if first[i].MetaFor == nil && first[i].Meta.MetaFor != first[i] {
+			t.Errorf("%s: meta changed by the second load", first[i].Name)
+		}
+	}
+
+	// ids are computed again on each load
+	ObjectIDHashVersion = CustomHash
+	ObjectIDHasher = func(definition *Definition) uint32 { return GCSObjectIDHash(definition) + 2 }
+	hashed := testDefinitions(t)
+	for i := range first {
+		if first[i].MetaFor == nil && hashed[i].ObjectID != first[i].ObjectID+2 {
+			t.Errorf("%s: id %d with the new hasher, was %d", first[i].Name, hashed[i].ObjectID, first[i].ObjectID)
+		}
+	}
+}
+
+func TestDefinitionCacheFileChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "definitions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source, err := ioutil.ReadFile(filepath.Join("testdata", "label.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(dir, "label.xml")
+	if err := ioutil.WriteFile(filePath, source, 0644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := newDefinitions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := strings.Replace(string(source), `type="uint16"`, `type="int32"`, 1)
+	if err := ioutil.WriteFile(filePath, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	after, err := newDefinitions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if before[0].ObjectID == after[0].ObjectID || after[0].Fields.ByteLength() != before[0].Fields.ByteLength()+2 {
+		t.Errorf("file not parsed again: id %d, %d bytes", after[0].ObjectID, after[0].Fields.ByteLength())
+	}
+}
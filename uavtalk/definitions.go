@@ -1,7 +1,6 @@
 package uavtalk
 
 import (
-	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -18,13 +17,32 @@ import (
 type Definitions []*Definition
 
 // GetDefinitionForObjectID _
+// meta object ids are resolved through their parent when the meta definition is not in the slice.
 func (definitions Definitions) GetDefinitionForObjectID(objectID uint32) (*Definition, error) {
 	for _, definition := range definitions {
 		if definition.ObjectID == objectID {
 			return definition, nil
 		}
 	}
-	return nil, errors.New(fmt.Sprint(objectID, " Not found"))
+	if IsMetaObjectID(objectID) {
+		parentID := objectID &^ 1
+		for _, definition := range definitions {
+			if definition.ObjectID == parentID && definition.Meta != nil {
+				return definition.Meta, nil
+			}
+		}
+	}
+	return nil, newCodecError(ErrUnknownObject, "%d Not found", objectID)
+}
+
+// MetaObjectID returns the id of the meta object paired with a data object id
+func MetaObjectID(objectID uint32) uint32 {
+	return objectID | 1
+}
+
+// IsMetaObjectID tells whether an object id is the one of a meta object (low bit set)
+func IsMetaObjectID(objectID uint32) bool {
+	return objectID&1 == 1
 }
 
 // GetDefinitionForName _
@@ -34,7 +52,84 @@ func (definitions Definitions) GetDefinitionForName(name string) (*Definition, e
 			return definition, nil
 		}
 	}
-	return nil, errors.New(fmt.Sprint(name, " Not found"))
+	return nil, newCodecError(ErrUnknownObject, "%s Not found", name)
+}
+
+// MustGetDefinitionForName is GetDefinitionForName panicking when the object is not found, meant for tests and tools
+func (definitions Definitions) MustGetDefinitionForName(name string) *Definition {
+	definition, err := definitions.GetDefinitionForName(name)
+	if err != nil {
+		panic(fmt.Sprintf("uavtalk: no definition loaded for object %s", name))
+	}
+	return definition
+}
+
+// MergePolicy tells Definitions.Merge what to do with two definitions having the same name or object id
+type MergePolicy int
+
+const (
+	// MergeLastWins keeps the definition of the merged set
+	MergeLastWins MergePolicy = iota
+	// MergeErrorOnConflict fails the merge
+	MergeErrorOnConflict
+)
+
+// Merge returns a new set with the definitions of both sets, each definition of other replacing
+// all the ones with the same name or object id as allowed by policy, at the place of the first one.
+// Meta definitions go with their parent: the metas of the replaced definitions are removed,
+// a definition of other comes with its meta, or with a new one paired with a copy of it when it has none.
+func (definitions Definitions) Merge(other Definitions, policy MergePolicy) (Definitions, error) {
+	merged := make(Definitions, len(definitions), len(definitions)+2*len(other))
+	copy(merged, definitions)
+
+	for _, definition := range other {
+		if definition.MetaFor != nil {
+			// added with its parent
+			continue
+		}
+		if definition.Meta == nil {
+			definition = definition.Clone()
+			if _, err := NewMetaDefinition(definition); err != nil {
+				return nil, err
+			}
+		}
+
+		conflicts := make(map[*Definition]bool)
+		first := -1
+		for i, existing := range merged {
+			if existing.conflictsWith(definition) || existing.conflictsWith(definition.Meta) {
+				conflicts[existing] = true
+				if first < 0 {
+					first = i
+				}
+			}
+		}
+		if first < 0 {
+			merged = append(merged, definition, definition.Meta)
+			continue
+		}
+		if policy == MergeErrorOnConflict {
+			return nil, fmt.Errorf("%s conflicts with %s (object id %d)", definition.Name, merged[first].Name, merged[first].ObjectID)
+		}
+
+		replaced := make(Definitions, 0, len(merged)+2)
+		for i, existing := range merged {
+			if i == first {
+				replaced = append(replaced, definition, definition.Meta)
+			}
+			if conflicts[existing] || conflicts[existing.MetaFor] || conflicts[existing.Meta] {
+				continue
+			}
+			replaced = append(replaced, existing)
+		}
+		merged = replaced
+	}
+	return merged, nil
+}
+
+// conflictsWith tells whether two definitions have the same name or object id
+func (definition *Definition) conflictsWith(other *Definition) bool {
+	return strings.ToLower(definition.Name) == strings.ToLower(other.Name) || definition.ObjectID == other.ObjectID
 }
 
 // IsUniqueInstanceForObjectID an common is said unique when its number of instances is == 0 (which means, it is not an array)
@@ -46,6 +141,117 @@ func (definitions Definitions) IsUniqueInstanceForObjectID(objectID uint32) (boo
 	return definition.SingleInstance, nil
 }
 
+// maxFrameLength is the largest length the 16 bits length field of a frame can hold
+const maxFrameLength = 0xffff
+
+// Validate checks the definitions for problems that would make them unusable on the wire,
+// all the problems found are returned instead of stopping on the first one.
+// It works on definitions that went through FinishSetup or not.
+func (definitions Definitions) Validate() []error {
+	var problems []error
+	objectIDs := make(map[uint32]*Definition, len(definitions))
+	names := make(map[string]*Definition, len(definitions))
+
+	for _, definition := range definitions {
+		if other, ok := objectIDs[definition.ObjectID]; ok {
+			problems = append(problems, fmt.Errorf("%s and %s share the same object id %d", other.Name, definition.Name, definition.ObjectID))
+		} else {
+			objectIDs[definition.ObjectID] = definition
+		}
+		// names are looked up case insensitively
+		if other, ok := names[strings.ToLower(definition.Name)]; ok {
+			problems = append(problems, fmt.Errorf("%s and %s share the same name", other.Name, definition.Name))
+		} else {
+			names[strings.ToLower(definition.Name)] = definition
+		}
+
+		length := Layout.length(definition.SingleInstance)
+
+		for _, field := range definition.Fields {
+			// follow the clones up to the field holding the actual description
+			source := field
+			for hops := 0; len(source.CloneOf) != 0 && source.FieldTypeInfo == nil && hops < len(definition.Fields); hops++ {
+				clonedField, err := definition.Fields.FieldForName(source.CloneOf)
+				if err != nil {
+					break
+				}
+				source = clonedField
+			}
+			if len(source.CloneOf) != 0 && source.FieldTypeInfo == nil {
+				problems = append(problems, fmt.Errorf("%s.%s: cloned field %s not found", definition.Name, field.Name, source.CloneOf))
+				continue
+			}
+
+			typeInfo, err := TypeInfos.FieldTypeForString(source.Type)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s.%s: %s", definition.Name, field.Name, err))
+				continue
+			}
+
+			if typeInfo.Name == "enum" {
+				options := source.Options
+				if len(options) == 0 && len(source.OptionsAttr) > 0 {
+					options = strings.Split(sanitizeListString(source.OptionsAttr), ",")
+				}
+				if len(options) == 0 {
+					problems = append(problems, fmt.Errorf("%s.%s: enum without options", definition.Name, field.Name))
+				} else if len(options) > 1<<uint(8*typeInfo.Size) {
+					problems = append(problems, fmt.Errorf("%s.%s: %d options don't fit in %d byte", definition.Name, field.Name, len(options), typeInfo.Size))
+				}
+			}
+
+			elements := source.Elements
+			if len(source.ElementNamesAttr) > 0 {
+				elements = len(strings.Split(sanitizeListString(source.ElementNamesAttr), ","))
+			} else if len(source.ElementNames) > 0 {
+				elements = len(source.ElementNames)
+			}
+			if elements == 0 {
+				elements = 1
+			}
+			length += typeInfo.Size * elements
+		}
+
+		if length > maxFrameLength {
+			problems = append(problems, fmt.Errorf("%s: frame length %d exceeds %d", definition.Name, length, maxFrameLength))
+		}
+	}
+	return problems
+}
+
+// ObjectFrameSize is the size of a full frame (header, body and crc) of an object
+type ObjectFrameSize struct {
+	Name     string
+	ObjectID uint32
+	Length   int
+	// Chunked is true when the frame doesn't fit in a single HID report
+	Chunked bool
+}
+
+// TotalByteLength returns the sum of the body lengths of all the definitions
+func (definitions Definitions) TotalByteLength() int {
+	length := 0
+	for _, definition := range definitions {
+		length += definition.Fields.ByteLength()
+	}
+	return length
+}
+
+// FrameSizeReport returns the size of a full frame for each definition
+func (definitions Definitions) FrameSizeReport() []ObjectFrameSize {
+	report := make([]ObjectFrameSize, 0, len(definitions))
+	for _, definition := range definitions {
+		length := FrameSize(definition, ObjectCmd)
+		report = append(report, ObjectFrameSize{
+			Name:     definition.Name,
+			ObjectID: definition.ObjectID,
+			Length:   length,
+			Chunked:  length > MaxHIDFrameSize-2,
+		})
+	}
+	return report
+}
+
 // FieldTypeInfo Taulabs defines its fields as type names, with a given size implicitely implied
 type FieldTypeInfo struct {
 	Index int
@@ -66,6 +272,8 @@ var TypeInfos = TypeIndex{
 	&FieldTypeInfo{5, "uint32", 4},
 	&FieldTypeInfo{6, "float", 4},
 	&FieldTypeInfo{7, "enum", 1},
+	// a char buffer of Elements bytes presented as a Go string, a uint8 array on the wire
+	&FieldTypeInfo{8, "string", 1},
 }
 
 // FieldTypeForString _
@@ -100,6 +308,18 @@ func (fields FieldsSlice) FieldForName(name string) (*FieldDefinition, error) {
 	return nil, fmt.Errorf("Not found field name: %s", name)
 }
 
+// FieldOffset returns the field with the given name and its offset in the body of an object
+func (fields FieldsSlice) FieldOffset(name string) (*FieldDefinition, int, error) {
+	offset := 0
+	for _, field := range fields {
+		if field.Name == name {
+			return field, offset, nil
+		}
+		offset += field.FieldTypeInfo.Size * field.Elements
+	}
+	return nil, 0, fmt.Errorf("Not found field name: %s", name)
+}
+
 func (fields FieldsSlice) Len() int {
 	return len(fields)
 }
@@ -168,6 +388,68 @@ type Definition struct {
 	Fields FieldsSlice `xml:"field" json:"fields"`
 }
 
+// Clone returns a deep copy of the definition, fields included with what FinishSetup computed.
+// Meta and MetaFor are not copied, NewMetaDefinition pairs the copy with a meta definition of its own.
+func (definition *Definition) Clone() *Definition {
+	clone := *definition
+	clone.Meta = nil
+	clone.MetaFor = nil
+
+	clone.Fields = make(FieldsSlice, 0, len(definition.Fields))
+	for _, field := range definition.Fields {
+		fieldClone := *field
+		fieldClone.ElementNames = append([]string(nil), field.ElementNames...)
+		fieldClone.Options = append([]string(nil), field.Options...)
+		clone.Fields = append(clone.Fields, &fieldClone)
+	}
+	return &clone
+}
+
+// FieldDescriptor describes a field of a definition, meant for generating settings UIs.
+// Units is only a label from the xml definition, values are not scaled.
+type FieldDescriptor struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Units        string   `json:"units,omitempty"`
+	Elements     int      `json:"elements"`
+	ElementNames []string `json:"elementNames,omitempty"`
+	Options      []string `json:"options,omitempty"`
+}
+
+// FieldDescriptors returns a descriptor for each field, in the order they are on the wire
+func (definition *Definition) FieldDescriptors() []FieldDescriptor {
+	descriptors := make([]FieldDescriptor, 0, len(definition.Fields))
+	for _, field := range definition.Fields {
+		descriptors = append(descriptors, FieldDescriptor{
+			Name:         field.Name,
+			Type:         field.Type,
+			Units:        field.Units,
+			Elements:     field.Elements,
+			ElementNames: append([]string(nil), field.ElementNames...),
+			Options:      append([]string(nil), field.Options...),
+		})
+	}
+	return descriptors
+}
+
+// ValidateData checks that data holds a valid value for each field of the definition and nothing else
+func (definition *Definition) ValidateData(data map[string]interface{}) error {
+	for name := range data {
+		if _, err := definition.Fields.FieldForName(name); err != nil {
+			return fmt.Errorf("%s: unknown field %s", definition.Name, name)
+		}
+	}
+	for _, field := range definition.Fields {
+		if _, ok := data[field.Name]; ok == false {
+			return fmt.Errorf("%s: missing field %s", definition.Name, field.Name)
+		}
+	}
+	if _, err := mapToUAVTalk(definition, data); err != nil {
+		return fmt.Errorf("%s: %s", definition.Name, err)
+	}
+	return nil
+}
+
 func (definition *Definition) fieldProcess() error {
 	var err error
 	// fields post process
@@ -195,18 +477,40 @@ func (definition *Definition) fieldProcess() error {
 		if err != nil {
 			return err
 		}
+
+		if field.FieldTypeInfo.Name == "enum" {
+			if len(field.Options) == 0 {
+				return fmt.Errorf("%s.%s: enum without options", definition.Name, field.Name)
+			} else if len(field.Options) > 1<<uint(8*field.FieldTypeInfo.Size) {
+				return fmt.Errorf("%s.%s: %d options don't fit in %d byte", definition.Name, field.Name, len(field.Options), field.FieldTypeInfo.Size)
+			}
+		}
 	}
 
-	// create clones
-	for _, field := range definition.Fields {
-		if len(field.CloneOf) != 0 {
+	// create clones, a clone can itself be cloned whatever the order of the fields,
+	// so clones are materialized until none is left, ByteLength and calculateID need them all.
+	for pending := true; pending; {
+		pending = false
+		progress := false
+		for _, field := range definition.Fields {
+			if len(field.CloneOf) == 0 || field.FieldTypeInfo != nil {
+				continue
+			}
 			clonedField, err := definition.Fields.FieldForName(field.CloneOf)
 			if err != nil {
 				return err
 			}
+			if clonedField.FieldTypeInfo == nil {
+				pending = true
+				continue
+			}
 			name, cloneOf := field.Name, field.CloneOf
 			*field = *clonedField
 			field.Name, field.CloneOf = name, cloneOf
+			progress = true
+		}
+		if pending && progress == false {
+			return fmt.Errorf("%s: circular cloneof between fields", definition.Name)
 		}
 	}
 	return nil
@@ -239,7 +543,7 @@ func NewMetaDefinition(parent *Definition) (*Definition, error) {
 	meta.SingleInstance = true
 	meta.Settings = false
 
-	meta.ObjectID = parent.ObjectID + 1
+	meta.ObjectID = MetaObjectID(parent.ObjectID)
 
 	meta.MetaFor = parent
 	parent.Meta = meta
@@ -0,0 +1,243 @@
+package uavtalk
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	base := testDefinitions(t)
+	label := base.MustGetDefinitionForName("Label")
+	waypoint := base.MustGetDefinitionForName("Waypoint")
+
+	// named as Label, with the id of Waypoint, and without meta
+	replacement := label.Clone()
+	replacement.ObjectID = waypoint.ObjectID
+	added := base.MustGetDefinitionForName("AttitudeActual").Clone()
+	added.Name = "Added"
+	added.ObjectID += 2
+	if _, err := NewMetaDefinition(added); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := base.Merge(Definitions{replacement}, MergeErrorOnConflict); err == nil {
+		t.Error("conflicting merge succeeded")
+	}
+
+	merged, err := base.Merge(Definitions{replacement, added, added.Meta}, MergeLastWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replacement.Meta != nil {
+		t.Error("the merged definition was modified")
+	}
+	// Label, Waypoint and their metas replaced by two pairs
+	if len(merged) != len(base) {
+		t.Errorf("%d definitions merged, expected %d", len(merged), len(base))
+	}
+
+	names := make(map[string]int)
+	ids := make(map[uint32]int)
+	for _, definition := range merged {
+		names[definition.Name]++
+		ids[definition.ObjectID]++
+		if definition == label || definition == waypoint || definition == label.Meta || definition == waypoint.Meta {
+			t.Errorf("%s %p is still there", definition.Name, definition)
+		}
+		// metas follow their parent
+		if definition.MetaFor == nil && definition.Meta == nil {
+			t.Errorf("%s has no meta", definition.Name)
+		}
+	}
+	for name, count := range names {
+		if count > 1 {
+			t.Errorf("%d definitions named %s", count, name)
+		}
+	}
+	for id, count := range ids {
+		if count > 1 {
+			t.Errorf("%d definitions with id %d", count, id)
+		}
+	}
+
+	merged, err = merged.Merge(Definitions{added.Clone()}, MergeLastWins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if definition := merged.MustGetDefinitionForName("Added"); definition == added || definition.Meta == nil || definition.Meta == added.Meta {
+		t.Error("Added was not replaced with its meta")
+	}
+	if _, err := merged.GetDefinitionForObjectID(added.Meta.ObjectID); err != nil {
+		t.Error(err)
+	}
+}
+
+// testDefinition returns a single instance definition with the given fields, as read from xml
+func testDefinition(name string, objectID uint32, fields ...*FieldDefinition) *Definition {
+	return &Definition{Name: name, ObjectID: objectID, SingleInstance: true, Fields: fields}
+}
+
+func TestValidate(t *testing.T) {
+	value := &FieldDefinition{Name: "Value", Type: "uint16"}
+	tests := []struct {
+		definitions Definitions
+		problems    []string
+	}{
+		{Definitions{testDefinition("A", 2, value), testDefinition("B", 4, value)}, nil},
+		{Definitions{testDefinition("A", 2, value), testDefinition("B", 2, value)}, []string{"A and B share the same object id 2"}},
+		{Definitions{testDefinition("Label", 2, value), testDefinition("label", 4, value)}, []string{"Label and label share the same name"}},
+		{Definitions{testDefinition("A", 2, &FieldDefinition{Name: "Text", Type: "uint8", Elements: 0x10000})}, []string{"A: frame length 65544 exceeds 65535"}},
+		{Definitions{testDefinition("A", 2, &FieldDefinition{Name: "Value", Type: "uint128"})}, []string{"A.Value: "}},
+		{Definitions{testDefinition("A", 2, &FieldDefinition{Name: "Copy", CloneOf: "Value"})}, []string{"A.Copy: cloned field Value not found"}},
+		{Definitions{testDefinition("A", 2, &FieldDefinition{Name: "Mode", Type: "enum"})}, []string{"A.Mode: enum without options"}},
+		{Definitions{testDefinition("A", 2, &FieldDefinition{Name: "Mode", Type: "enum", Options: make([]string, 257)})}, []string{"A.Mode: 257 options don't fit in 1 byte"}},
+		// all the problems are reported
+		{Definitions{testDefinition("A", 2, &FieldDefinition{Name: "Mode", Type: "enum"}), testDefinition("a", 2, value)}, []string{
+			"A.Mode: enum without options", "A and a share the same object id 2", "A and a share the same name",
+		}},
+	}
+
+	for i, test := range tests {
+		problems := test.definitions.Validate()
+		if len(problems) != len(test.problems) {
+			t.Errorf("case %d: got %v, expected %v", i, problems, test.problems)
+			continue
+		}
+		for j, problem := range problems {
+			if strings.HasPrefix(problem.Error(), test.problems[j]) == false {
+				t.Errorf("case %d: got %q, expected %q", i, problem, test.problems[j])
+			}
+		}
+	}
+}
+
+func TestFieldDescriptors(t *testing.T) {
+	definition := testDefinitions(t).MustGetDefinitionForName("Waypoint")
+
+	// in the order of the fields on the wire
+	expected := []FieldDescriptor{
+		{Name: "Position", Type: "float", Units: "m", Elements: 3, ElementNames: []string{"North", "East", "Down"}},
+		{Name: "Velocity", Type: "float", Units: "m/s", Elements: 1},
+		{Name: "Distance", Type: "int32", Units: "cm", Elements: 1},
+		{Name: "Counter", Type: "int16", Elements: 2},
+		{Name: "Action", Type: "enum", Elements: 1, Options: []string{"None", "Land", "Loiter"}},
+		{Name: "Modes", Type: "enum", Elements: 4, Options: []string{"Off", "On"}},
+		{Name: "Sign", Type: "int8", Elements: 1},
+	}
+	descriptors := definition.FieldDescriptors()
+	if reflect.DeepEqual(descriptors, expected) == false {
+		t.Errorf("got\n%+v\nexpected\n%+v", descriptors, expected)
+	}
+
+	// the descriptors don't share the definition slices
+	descriptors[0].ElementNames[0] = "Up"
+	if definition.Fields[0].ElementNames[0] != "North" {
+		t.Error("definition changed through its descriptors")
+	}
+}
+
+func TestClonedFields(t *testing.T) {
+	object := `<xml><object name="Gains" singleinstance="true" settings="true"><description>gains</description>%s
+		<access gcs="readwrite" flight="readwrite"/><telemetrygcs acked="true" updatemode="onchange" period="0"/>
+		<telemetryflight acked="true" updatemode="onchange" period="0"/><logging updatemode="manual" period="0"/>
+		</object></xml>`
+	// a clone can be declared before the field it clones
+	cloned := writeDefinitionsDir(t, map[string]string{"gains.xml": fmt.Sprintf(object, `
+		<field name="Limits" cloneof="Rates"/>
+		<field name="Rates" units="" type="float" elementnames="Roll,Pitch,Yaw"/>
+		<field name="Mode" units="" type="enum" elements="1" options="Off,On"/>
+		<field name="Attitude" cloneof="Rates"/>`)})
+	defer os.RemoveAll(cloned)
+	explicit := writeDefinitionsDir(t, map[string]string{"gains.xml": fmt.Sprintf(object, `
+		<field name="Limits" units="" type="float" elementnames="Roll,Pitch,Yaw"/>
+		<field name="Rates" units="" type="float" elementnames="Roll,Pitch,Yaw"/>
+		<field name="Mode" units="" type="enum" elements="1" options="Off,On"/>
+		<field name="Attitude" units="" type="float" elementnames="Roll,Pitch,Yaw"/>`)})
+	defer os.RemoveAll(explicit)
+
+	clonedDefs, err := newDefinitions(cloned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	explicitDefs, err := newDefinitions(explicit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	definition, expected := clonedDefs.MustGetDefinitionForName("Gains"), explicitDefs.MustGetDefinitionForName("Gains")
+	if length := definition.Fields.ByteLength(); length != 37 || length != expected.Fields.ByteLength() {
+		t.Errorf("%d bytes, %d declared explicitly", length, expected.Fields.ByteLength())
+	}
+	if definition.ObjectID != expected.ObjectID {
+		t.Errorf("object id %#x, %#x declared explicitly", definition.ObjectID, expected.ObjectID)
+	}
+	limits, err := definition.Fields.FieldForName("Limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.CloneOf != "Rates" || reflect.DeepEqual(limits.ElementNames, []string{"Roll", "Pitch", "Yaw"}) == false {
+		t.Errorf("clone %+v", limits)
+	}
+}
+
+func TestFrameSizeReport(t *testing.T) {
+	defs := testDefinitions(t)
+	// single instance frames of 8 bytes of header, the body and 1 byte of crc, the largest fitting in a HID report
+	fits := testDefinition("Fits", 2, &FieldDefinition{Name: "Data", Type: "uint8", Elements: MaxHIDFrameSize - 2 - 9})
+	chunked := testDefinition("Chunked", 4, &FieldDefinition{Name: "Data", Type: "uint8", Elements: MaxHIDFrameSize - 2 - 8})
+	for _, definition := range []*Definition{fits, chunked} {
+		if err := definition.FinishSetup(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defs = append(defs, fits, chunked)
+
+	report := defs.FrameSizeReport()
+	if len(report) != len(defs) {
+		t.Fatalf("%d objects reported", len(report))
+	}
+	total := 0
+	for i, size := range report {
+		definition := defs[i]
+		total += definition.Fields.ByteLength()
+		if size.Name != definition.Name || size.ObjectID != definition.ObjectID || size.Length != FrameSize(definition, ObjectCmd) {
+			t.Errorf("%s: got %+v", definition.Name, size)
+		}
+		if size.Chunked != (definition == chunked) {
+			t.Errorf("%s: %d bytes, chunked %t", size.Name, size.Length, size.Chunked)
+		}
+	}
+	if report[len(report)-1].Length != MaxHIDFrameSize-1 {
+		t.Errorf("Chunked: %d bytes", report[len(report)-1].Length)
+	}
+	if defs.TotalByteLength() != total {
+		t.Errorf("TotalByteLength %d, expected %d", defs.TotalByteLength(), total)
+	}
+}
+
+func TestEnumOptionsLimit(t *testing.T) {
+	object := `<xml><object name="Modes" singleinstance="true" settings="false"><description>modes</description>
+		<field name="Mode" units="" type="enum" elements="1" options="%s"/>
+		<access gcs="readwrite" flight="readwrite"/><telemetrygcs acked="false" updatemode="manual" period="0"/>
+		<telemetryflight acked="false" updatemode="periodic" period="1000"/><logging updatemode="manual" period="0"/>
+		</object></xml>`
+
+	for _, count := range []int{256, 257} {
+		options := make([]string, count)
+		for i := range options {
+			options[i] = fmt.Sprintf("Mode%d", i)
+		}
+		dir := writeDefinitionsDir(t, map[string]string{"modes.xml": fmt.Sprintf(object, strings.Join(options, ","))})
+		defer os.RemoveAll(dir)
+
+		_, err := newDefinitions(dir)
+		if count <= 256 && err != nil {
+			t.Errorf("%d options: %s", count, err)
+		}
+		if count > 256 && (err == nil || strings.Contains(err.Error(), "Modes.Mode: 257 options don't fit in 1 byte") == false) {
+			t.Errorf("%d options: got %v", count, err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package uavtalk
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// CachedEncoder encodes packets for a given definition, cmd and instance into a preallocated frame,
+// the header is written once and only the fields that changed and the crc are rewritten on each Encode.
+// Useful for objects sent periodically at high rates.
+// Frames end with the FrameChecksum of when the encoder was created.
+type CachedEncoder struct {
+	definition   *Definition
+	cmd          uint8
+	checksum     Checksum
+	headerLength int
+	frame        []byte
+	fields       []cachedField
+	scratch      *bytes.Buffer
+}
+
+// cachedField is where a field is in the frame, and the value last written there
+type cachedField struct {
+	field  *FieldDefinition
+	offset int
+	size   int
+
+	written bool
+	// last is only kept for single element values, which can be compared
+	last interface{}
+}
+
+// NewCachedEncoder returns a CachedEncoder for the given definition, cmd and instance
+func NewCachedEncoder(definition *Definition, cmd uint8, instanceID uint16) (*CachedEncoder, error) {
+	packet := NewPacket(definition, cmd, instanceID, nil)
+
+	header := new(bytes.Buffer)
+	if err := packet.writeHeader(header, cmd|versionMask); err != nil {
+		return nil, err
+	}
+
+	checksum := FrameChecksum
+	frame := make([]byte, int(packet.Length)+checksum.Size())
+	copy(frame, header.Bytes())
+
+	encoder := &CachedEncoder{
+		definition:   definition,
+		cmd:          cmd,
+		checksum:     checksum,
+		headerLength: header.Len(),
+		frame:        frame,
+		scratch:      new(bytes.Buffer),
+	}
+	if cmd == ObjectCmd || cmd == ObjectCmdWithAck {
+		for _, field := range definition.Fields {
+			_, offset, err := definition.Fields.FieldOffset(field.Name)
+			if err != nil {
+				return nil, err
+			}
+			encoder.fields = append(encoder.fields, cachedField{field: field, offset: header.Len() + offset, size: field.FieldTypeInfo.Size * field.Elements})
+		}
+	}
+	return encoder, nil
+}
+
+// Encode returns the binary frame for data, data is ignored for commands without body.
+// Fields missing from data keep the value of the previous call, the first call needs them all.
+// The returned slice is reused by the next call to Encode.
+func (encoder *CachedEncoder) Encode(data map[string]interface{}) ([]byte, error) {
+	for i := range encoder.fields {
+		cached := &encoder.fields[i]
+		value, ok := data[cached.field.Name]
+		if ok == false {
+			if cached.written == false {
+				return nil, fmt.Errorf("%s: missing field %s", encoder.definition.Name, cached.field.Name)
+			}
+			continue
+		}
+		if cached.written && cached.last != nil && comparableValue(value) && value == cached.last {
+			continue
+		}
+
+		encoder.scratch.Reset()
+		if err := interfaceToUAVTalk(cached.field, encoder.scratch, value); err != nil {
+			return nil, err
+		}
+		if encoder.scratch.Len() != cached.size {
+			return nil, fmt.Errorf("%s.%s: encoded %d bytes, expected %d", encoder.definition.Name, cached.field.Name, encoder.scratch.Len(), cached.size)
+		}
+		copy(encoder.frame[cached.offset:], encoder.scratch.Bytes())
+		cached.written = true
+		cached.last = nil
+		if comparableValue(value) {
+			cached.last = value
+		}
+	}
+
+	crcOffset := len(encoder.frame) - encoder.checksum.Size()
+	copy(encoder.frame[crcOffset:], encoder.checksum.Sum(encoder.frame[:crcOffset]))
+	return encoder.frame, nil
+}
+
+// comparableValue tells whether a field value is of a type that can be compared with ==
+func comparableValue(value interface{}) bool {
+	switch value.(type) {
+	case float64, string, bool, int, uint8:
+		return true
+	}
+	return false
+}
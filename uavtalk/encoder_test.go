@@ -0,0 +1,108 @@
+package uavtalk
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCachedEncoder(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+
+	encoder, err := NewCachedEncoder(definition, ObjectCmd, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encoder.Encode(map[string]interface{}{"Velocity": float64(1)}); err == nil {
+		t.Fatal("first Encode without all the fields")
+	}
+
+	// each update is applied over the previous data
+	updates := []map[string]interface{}{
+		waypointData(),
+		{},
+		{"Velocity": float64(4.5)},
+		{"Velocity": float64(4.5), "Action": "Land"},
+		{"Modes": []interface{}{"Off", "Off", "Off", "On"}, "Sign": float64(7)},
+		{"Position": map[string]interface{}{"North": float64(0), "East": float64(0), "Down": float64(-1)}},
+		waypointData(),
+	}
+	data := map[string]interface{}{}
+	for i, update := range updates {
+		for name, value := range update {
+			data[name] = value
+		}
+		expected, err := NewPacket(definition, ObjectCmd, 5, data).toBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame, err := encoder.Encode(update)
+		if err != nil {
+			t.Fatalf("update %d: %s", i, err)
+		}
+		if bytes.Equal(frame, expected) == false {
+			t.Errorf("update %d: encoded %x, expected %x", i, frame, expected)
+		}
+	}
+
+	// a field failing to encode doesn't change the others
+	if _, err := encoder.Encode(map[string]interface{}{"Action": "Hover"}); err == nil {
+		t.Error("encoded a wrong enum option")
+	}
+	frame, err := encoder.Encode(map[string]interface{}{})
+	if err != nil || bytes.Equal(frame, encodeTestPacket(t, "Waypoint", ObjectCmd, 5, waypointData())) == false {
+		t.Errorf("encoded %x %v after an error", frame, err)
+	}
+}
+
+func TestCachedEncoderWithoutBody(t *testing.T) {
+	loadTestDefinitions(t)
+
+	encoder, err := NewCachedEncoder(AllDefinitions.MustGetDefinitionForName("Waypoint"), ObjectRequest, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := encoder.Encode(nil)
+	if err != nil || bytes.Equal(frame, encodeTestPacket(t, "Waypoint", ObjectRequest, 2, nil)) == false {
+		t.Errorf("encoded %x %v", frame, err)
+	}
+
+	if _, err := NewCachedEncoder(AllDefinitions.MustGetDefinitionForName("Label"), ObjectCmd, 1); err == nil {
+		t.Error("encoder for instance 1 of a single instance object")
+	}
+}
+
+// BenchmarkEncode encodes a new packet on each iteration, as toBinary does for every packet sent
+func BenchmarkEncode(b *testing.B) {
+	loadTestDefinitions(b)
+	definition := AllDefinitions.MustGetDefinitionForName("AttitudeActual")
+	data := attitudeData()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data["Yaw"] = float64(i % 360)
+		if _, err := NewPacket(definition, ObjectCmd, 0, data).toBinary(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCachedEncoder is BenchmarkEncode with a CachedEncoder, a single field changing on each iteration
+func BenchmarkCachedEncoder(b *testing.B) {
+	loadTestDefinitions(b)
+	encoder, err := NewCachedEncoder(AllDefinitions.MustGetDefinitionForName("AttitudeActual"), ObjectCmd, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := attitudeData()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data["Yaw"] = float64(i % 360)
+		if _, err := encoder.Encode(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package uavtalk
+
+import (
+	"errors"
+	"fmt"
+)
+
+/**
+ * Errors returned by the codec and the link are of one of the kinds below, so callers can react to the kind of failure.
+ * ErrBadCRC and ErrOutOfSync are returned as is, the others carry a detailed message and are matched with errors.Is,
+ * or by comparing ErrorKind(err) on toolchains older than go1.13.
+ */
+
+// Kinds of codec errors
+var (
+	ErrUnknownObject  = errors.New("Unknown object")
+	ErrBadCRC         = errors.New("Wrong checksum")
+	ErrShortBuffer    = errors.New("Buffer too short")
+	ErrLengthMismatch = errors.New("Length mismatch")
+)
+
+// Kinds of link errors, the link is closed and opened again after any of them
+var (
+	ErrLinkLost   = errors.New("Link lost")
+	ErrOutOfSync  = errors.New("Too many checksum errors, link out of sync")
+	ErrShortWrite = errors.New("Short write")
+)
+
+// codecError is an error of one of the kinds above
+type codecError struct {
+	kind    error
+	message string
+}
+
+func (err *codecError) Error() string {
+	return err.message
+}
+
+// Unwrap returns the kind of the error, for errors.Is
+func (err *codecError) Unwrap() error {
+	return err.kind
+}
+
+func newCodecError(kind error, format string, args ...interface{}) error {
+	return &codecError{kind, fmt.Sprintf(format, args...)}
+}
+
+// linkLostError returns err as an ErrLinkLost error, unless it already has a kind
+func linkLostError(err error) error {
+	if _, ok := err.(*codecError); ok || err == ErrOutOfSync {
+		return err
+	}
+	return newCodecError(ErrLinkLost, "Link lost: %s", err)
+}
+
+// ErrorKind returns the kind of a codec error, or err itself when it has no kind
+func ErrorKind(err error) error {
+	if codecErr, ok := err.(*codecError); ok {
+		return codecErr.kind
+	}
+	return err
+}
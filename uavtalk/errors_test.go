@@ -0,0 +1,36 @@
+package uavtalk
+
+import (
+	"io"
+	"testing"
+)
+
+func TestLinkErrorKinds(t *testing.T) {
+	loadTestDefinitions(t)
+	links, restore := mockLinks()
+	defer restore()
+
+	done := make(chan struct{})
+	go func() {
+		start(make(chan Packet), make(chan Packet, 1))
+		close(done)
+	}()
+	link := <-links
+	link.lock.Lock()
+	link.writeErr = io.ErrClosedPipe
+	link.lock.Unlock()
+
+	frame := encodeTestPacket(t, "AttitudeActual", ObjectRequest, 0, nil)
+	if err := SendRaw(frame); ErrorKind(err) != ErrLinkLost {
+		t.Errorf("write failed with %v, expected a lost link", err)
+	}
+	<-done
+
+	// the kinds of the link errors are kept
+	shortWrite := newCodecError(ErrShortWrite, "Short HID write")
+	for _, err := range []error{shortWrite, ErrOutOfSync} {
+		if lost := linkLostError(err); lost != err {
+			t.Errorf("%v returned as %v", err, lost)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package uavtalk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// loadTestDefinitions loads the definitions of testdata into AllDefinitions
+func loadTestDefinitions(tb testing.TB) {
+	defs, err := newDefinitions("testdata")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	setDefinitions(defs)
+}
+
+// writeDefinitionsDir writes files, by name, to a new temporary directory to be removed by the caller
+func writeDefinitionsDir(tb testing.TB, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "definitions")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			os.RemoveAll(dir)
+			tb.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// testDefinitions returns the definitions of testdata, without touching AllDefinitions
+func testDefinitions(tb testing.TB) Definitions {
+	defs, err := newDefinitions("testdata")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return defs
+}
+
+func waypointData() map[string]interface{} {
+	return map[string]interface{}{
+		"Position": map[string]interface{}{"North": float64(1.5), "East": float64(-2), "Down": float64(-10.25)},
+		"Velocity": float64(3),
+		"Distance": float64(-2147483648),
+		"Action":   "Loiter",
+		"Modes":    []interface{}{"On", "Off", float64(1), "Off"},
+		"Counter":  []interface{}{float64(-32768), float64(32767)},
+		"Sign":     float64(-1),
+	}
+}
+
+func attitudeData() map[string]interface{} {
+	return map[string]interface{}{
+		"q1": float64(1), "q2": float64(0), "q3": float64(0), "q4": float64(0),
+		"Roll": float64(12.5), "Pitch": float64(-3.25), "Yaw": float64(180),
+	}
+}
+
+func labelData() map[string]interface{} {
+	return map[string]interface{}{"Text": "quad450", "Value": float64(65535), "Enabled": "True"}
+}
+
+// encodeTestPacket returns the frame of a packet for the object with the given name
+func encodeTestPacket(tb testing.TB, name string, cmd uint8, instanceID uint16, data map[string]interface{}) []byte {
+	packet := NewPacket(AllDefinitions.MustGetDefinitionForName(name), cmd, instanceID, data)
+	frame, err := packet.toBinary()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return frame
+}
+
+// testBody returns the body of the frame encoding data for the object with the given name
+func testBody(tb testing.TB, name string, data map[string]interface{}) []byte {
+	definition := AllDefinitions.MustGetDefinitionForName(name)
+	frame := encodeTestPacket(tb, name, ObjectCmd, 0, data)
+	return frame[Layout.length(definition.SingleInstance) : len(frame)-FrameChecksum.Size()]
+}
+
+// sealFrame sets the length field of a frame without checksum and appends its checksum
+func sealFrame(frame []byte) []byte {
+	length := uint16(len(frame))
+	frame[2], frame[3] = byte(length), byte(length>>8)
+	return append(frame, FrameChecksum.Sum(frame)...)
+}
+
+// timestampFrame returns a copy of frame carrying timestamp
+func timestampFrame(frame []byte, singleInstance bool, timestamp uint16) []byte {
+	headerLength := Layout.length(singleInstance)
+	timestamped := append([]byte(nil), frame[:headerLength]...)
+	timestamped = append(timestamped, byte(timestamp), byte(timestamp>>8))
+	timestamped = append(timestamped, frame[headerLength:len(frame)-FrameChecksum.Size()]...)
+	timestamped[1] |= timestampedMask
+	return sealFrame(timestamped)
+}
+
+// corruptFrame returns a copy of frame with a wrong checksum
+func corruptFrame(frame []byte) []byte {
+	corrupted := append([]byte(nil), frame...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	return corrupted
+}
+
+// manualClock is a Clock whose time only moves with Advance, firing the timers then due
+type manualClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+type manualTimer struct {
+	clock    *manualClock
+	deadline time.Time
+	c        chan time.Time
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Unix(1000, 0)}
+}
+
+func (clock *manualClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return clock.now
+}
+
+func (clock *manualClock) NewTimer(d time.Duration) Timer {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	timer := &manualTimer{clock, clock.now.Add(d), make(chan time.Time, 1)}
+	clock.timers = append(clock.timers, timer)
+	return timer
+}
+
+// Advance moves the time forward by d
+func (clock *manualClock) Advance(d time.Duration) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	clock.now = clock.now.Add(d)
+	pending := clock.timers[:0]
+	for _, timer := range clock.timers {
+		if timer.deadline.After(clock.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.c <- clock.now
+	}
+	clock.timers = pending
+}
+
+// waitTimers waits for n timers to be pending
+func (clock *manualClock) waitTimers(tb testing.TB, n int) {
+	for i := 0; i < 1000; i++ {
+		clock.lock.Lock()
+		pending := len(clock.timers)
+		clock.lock.Unlock()
+		if pending >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	tb.Fatalf("%d timers never pending", n)
+}
+
+func (timer *manualTimer) C() <-chan time.Time {
+	return timer.c
+}
+
+func (timer *manualTimer) Stop() bool {
+	timer.clock.lock.Lock()
+	defer timer.clock.lock.Unlock()
+	for i, pending := range timer.clock.timers {
+		if pending == timer {
+			timer.clock.timers = append(timer.clock.timers[:i], timer.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// mockLink is a Linker reading the frames given to reads, its Read returns 0 bytes after a millisecond without any.
+// Writes fail once writeErr is set.
+type mockLink struct {
+	reads  chan []byte
+	writes chan []byte
+
+	lock           sync.Mutex
+	closed         bool
+	usedAfterClose bool
+	writeErr       error
+}
+
+func newMockLink() *mockLink {
+	return &mockLink{reads: make(chan []byte, 16), writes: make(chan []byte, 16)}
+}
+
+func (link *mockLink) use() {
+	link.lock.Lock()
+	defer link.lock.Unlock()
+	if link.closed {
+		link.usedAfterClose = true
+	}
+}
+
+func (link *mockLink) Read(b []byte) (int, error) {
+	link.use()
+	select {
+	case frame := <-link.reads:
+		return copy(b, frame), nil
+	case <-time.After(time.Millisecond):
+		return 0, nil
+	}
+}
+
+func (link *mockLink) Write(b []byte) (int, error) {
+	link.use()
+	link.lock.Lock()
+	err := link.writeErr
+	link.lock.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	link.writes <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+func (link *mockLink) Close() error {
+	link.lock.Lock()
+	defer link.lock.Unlock()
+	link.closed = true
+	return nil
+}
+
+// mockLinks makes OpenLink return new mock links, passed to the returned channel, until restored
+func mockLinks() (links chan *mockLink, restore func()) {
+	links = make(chan *mockLink, 4)
+	OpenLink = func() (Linker, error) {
+		link := newMockLink()
+		links <- link
+		return link, nil
+	}
+	return links, func() { OpenLink = NewUSBLink }
+}
+
+// pairTimeout bounds the waits of controllerPair
+const pairTimeout = time.Second
+
+// controllerPair is a connected Client paired in memory with a mock flight controller, see mockController
+type controllerPair struct {
+	tb     testing.TB
+	client *Client
+	sent   chan Packet
+	quit   chan struct{}
+}
+
+// newControllerPair returns a client connected to a mock controller, to be closed by the caller
+func newControllerPair(tb testing.TB) *controllerPair {
+	pair := &controllerPair{tb: tb, client: NewClient(), sent: make(chan Packet, 16), quit: make(chan struct{})}
+	pair.client.start = mockController(pair.sent, pair.quit)
+	if err := pair.client.Connect(pairTimeout); err != nil {
+		close(pair.quit)
+		tb.Fatal(err)
+	}
+	return pair
+}
+
+// Send passes packet to the client as received from the controller
+func (pair *controllerPair) Send(packet Packet) {
+	select {
+	case pair.client.outChan <- packet:
+	case <-time.After(pairTimeout):
+		pair.tb.Fatalf("%s not taken by the client", packet.Definition.Name)
+	}
+}
+
+// Recv returns the next packet sent by the client, the handshake excluded
+func (pair *controllerPair) Recv() Packet {
+	select {
+	case packet := <-pair.sent:
+		return packet
+	case <-time.After(pairTimeout):
+		pair.tb.Fatal("nothing sent by the client")
+	}
+	return Packet{}
+}
+
+// Close stops the mock controller
+func (pair *controllerPair) Close() {
+	close(pair.quit)
+}
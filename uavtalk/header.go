@@ -0,0 +1,107 @@
+package uavtalk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+/**
+ * Frame header: sync byte, type, 16 bits length, object id, instance id (multi instance objects only).
+ * The width of the ids is given by a HeaderLayout so the bridge can talk to boards using other widths.
+ */
+
+// HeaderLayout gives the size in bytes of the ids in the frame header
+type HeaderLayout struct {
+	ObjectIDSize   int // 2 or 4
+	InstanceIDSize int // 1 or 2
+}
+
+// DefaultHeaderLayout is the current layout, 32 bits object ids and 16 bits instance ids
+var DefaultHeaderLayout = HeaderLayout{ObjectIDSize: 4, InstanceIDSize: 2}
+
+// Layout is the header layout used to encode and decode frames
+var Layout = DefaultHeaderLayout
+
+// length returns the size of the header for an object
+func (layout HeaderLayout) length(singleInstance bool) int {
+	length := 4 + layout.ObjectIDSize
+	if singleInstance == false {
+		length += layout.InstanceIDSize
+	}
+	return length
+}
+
+// objectIDMask returns the bits of an object id carried by the header
+func (layout HeaderLayout) objectIDMask() uint32 {
+	if layout.ObjectIDSize >= 4 {
+		return 0xffffffff
+	}
+	return 1<<uint(8*layout.ObjectIDSize) - 1
+}
+
+func (layout HeaderLayout) validate() error {
+	if layout.ObjectIDSize != 2 && layout.ObjectIDSize != 4 {
+		return fmt.Errorf("Unsupported object id size %d", layout.ObjectIDSize)
+	}
+	if layout.InstanceIDSize != 1 && layout.InstanceIDSize != 2 {
+		return fmt.Errorf("Unsupported instance id size %d", layout.InstanceIDSize)
+	}
+	return nil
+}
+
+func (layout HeaderLayout) writeIDs(writer *bytes.Buffer, objectID uint32, singleInstance bool, instanceID uint16) error {
+	if err := layout.validate(); err != nil {
+		return err
+	}
+
+	var id interface{} = objectID
+	if layout.ObjectIDSize == 2 {
+		id = uint16(objectID)
+	}
+	if err := binary.Write(writer, binary.LittleEndian, id); err != nil {
+		return err
+	}
+
+	if singleInstance == false {
+		var instance interface{} = instanceID
+		if layout.InstanceIDSize == 1 {
+			instance = uint8(instanceID)
+		}
+		if err := binary.Write(writer, binary.LittleEndian, instance); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readObjectID reads the object id found after the first 4 bytes of the frame
+func (layout HeaderLayout) readObjectID(frame []byte) uint32 {
+	if layout.ObjectIDSize == 2 {
+		return uint32(byteArrayToInt16(frame[4:6]))
+	}
+	return byteArrayToInt32(frame[4:8])
+}
+
+// readInstanceID reads the instance id of a multi instance object
+func (layout HeaderLayout) readInstanceID(frame []byte) uint16 {
+	offset := 4 + layout.ObjectIDSize
+	if layout.InstanceIDSize == 1 {
+		return uint16(frame[offset])
+	}
+	return byteArrayToInt16(frame[offset : offset+2])
+}
+
+// definitionForObjectID returns the definition for an object id read from a header
+func (layout HeaderLayout) definitionForObjectID(definitions Definitions, objectID uint32) (*Definition, error) {
+	mask := layout.objectIDMask()
+	if mask == 0xffffffff {
+		return definitions.GetDefinitionForObjectID(objectID)
+	}
+	for _, definition := range definitions {
+		if definition.ObjectID&mask == objectID {
+			return definition, nil
+		}
+	}
+	return nil, newCodecError(ErrUnknownObject, "%d Not found", objectID)
+}
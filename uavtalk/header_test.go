@@ -0,0 +1,50 @@
+package uavtalk
+
+import "testing"
+
+func TestHeaderLayouts(t *testing.T) {
+	loadTestDefinitions(t)
+	defer func() { Layout = DefaultHeaderLayout }()
+
+	tests := []struct {
+		layout     HeaderLayout
+		name       string
+		instanceID uint16
+		length     int
+	}{
+		{HeaderLayout{4, 2}, "AttitudeActual", 0, 8},
+		{HeaderLayout{4, 2}, "Waypoint", 300, 10},
+		{HeaderLayout{2, 2}, "Waypoint", 300, 8},
+		{HeaderLayout{4, 1}, "Waypoint", 200, 9},
+		{HeaderLayout{2, 1}, "AttitudeActual", 0, 6},
+	}
+
+	for _, test := range tests {
+		Layout = test.layout
+		definition := AllDefinitions.MustGetDefinitionForName(test.name)
+		if length := Layout.length(definition.SingleInstance); length != test.length {
+			t.Errorf("%v %s: header length %d, expected %d", test.layout, test.name, length, test.length)
+		}
+
+		frame := encodeTestPacket(t, test.name, ObjectRequest, test.instanceID, nil)
+		if len(frame) != test.length+1 {
+			t.Errorf("%v %s: %d bytes encoded, expected %d", test.layout, test.name, len(frame), test.length+1)
+		}
+		decoded, err := newPacketFromBinary(frame, FrameChecksum.Size())
+		if err != nil {
+			t.Errorf("%v %s: %s", test.layout, test.name, err)
+			continue
+		}
+		if decoded.Definition != definition || decoded.InstanceID != test.instanceID {
+			t.Errorf("%v %s: decoded %s instance %d", test.layout, test.name, decoded.Definition.Name, decoded.InstanceID)
+		}
+	}
+
+	for _, layout := range []HeaderLayout{{3, 2}, {4, 0}} {
+		Layout = layout
+		packet := NewPacket(AllDefinitions.MustGetDefinitionForName("Waypoint"), ObjectRequest, 0, nil)
+		if _, err := packet.toBinary(); err == nil {
+			t.Errorf("%v: encoded with an unsupported layout", layout)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package uavtalk
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// NewHealthHandler returns an http.Handler answering 200 when the link is open and handshakeDone returns true,
+// 503 otherwise, for liveness and readiness probes. Client.Connected can be used as handshakeDone.
+func NewHealthHandler(handshakeDone func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		linkUp := LinkUp()
+		connected := handshakeDone()
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if linkUp && connected {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintf(w, "link up: %t\nhandshake done: %t\n", linkUp, connected)
+	})
+}
@@ -0,0 +1,33 @@
+package uavtalk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthHandler(t *testing.T) {
+	defer setLinkState(false)
+
+	tests := []struct {
+		linkUp    bool
+		connected bool
+		status    int
+	}{
+		{false, false, http.StatusServiceUnavailable},
+		{true, false, http.StatusServiceUnavailable},
+		{false, true, http.StatusServiceUnavailable},
+		{true, true, http.StatusOK},
+	}
+	for _, test := range tests {
+		setLinkState(test.linkUp)
+		connected := test.connected
+		handler := NewHealthHandler(func() bool { return connected })
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, &http.Request{Method: "GET"})
+		if recorder.Code != test.status {
+			t.Errorf("link up %t, connected %t: status %d, expected %d", test.linkUp, test.connected, recorder.Code, test.status)
+		}
+	}
+}
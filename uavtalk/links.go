@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io"
 	"net"
+	"sync"
 
 	"github.com/GeertJohan/go.hid"
 )
@@ -13,15 +14,27 @@ import (
  * It currently supports USB HID and TCP links.
  */
 
+// Linker is a link to the flight controller. Read has to return regularly, with 0 bytes when nothing
+// was received (as the usb link does with its HID read timeout): the link is only closed once its reader is done.
 type Linker interface {
 	io.Reader
 	io.Writer
 	io.Closer
 }
 
+// hidDevice is the part of hid.Device used by usbLink
+type hidDevice interface {
+	Write(b []byte) (int, error)
+	ReadTimeout(b []byte, timeout int) (int, error)
+	Close()
+}
+
 type usbLink struct {
-	cc                     *hid.Device
+	cc                     hidDevice
 	fixedLengthWriteBuffer []byte
+
+	// writes are serialized by start, this only keeps the shared write buffer safe from any other caller
+	writeMutex *sync.Mutex
 }
 
 var _ Linker = (usbLink{})
@@ -64,10 +77,18 @@ Loop:
 		return nil, err
 	}
 
-	return usbLink{cc, make([]byte, MaxHIDFrameSize)}, nil
+	return usbLink{cc, make([]byte, MaxHIDFrameSize), &sync.Mutex{}}, nil
 }
 
 func (l usbLink) Write(b []byte) (int, error) {
+	l.writeMutex.Lock()
+	defer l.writeMutex.Unlock()
+
+	return writeHIDReports(l.cc, l.fixedLengthWriteBuffer, b)
+}
+
+// writeHIDReports writes b as HID reports of MaxHIDFrameSize bytes, report is the buffer used to build them
+func writeHIDReports(writer io.Writer, report []byte, b []byte) (int, error) {
 	currentOffset := 0
 	for currentOffset < len(b) {
 		toWriteLength := len(b) - currentOffset
@@ -77,17 +98,23 @@ func (l usbLink) Write(b []byte) (int, error) {
 		}
 
 		// USB HID link requires a reportID and packet length as first bytes
-		l.fixedLengthWriteBuffer[0] = 0x02
-		l.fixedLengthWriteBuffer[1] = byte(toWriteLength)
-		copy(l.fixedLengthWriteBuffer[2:], b[currentOffset:currentOffset+toWriteLength])
+		report[0] = 0x02
+		report[1] = byte(toWriteLength)
+		copy(report[2:], b[currentOffset:currentOffset+toWriteLength])
 
-		n, err := l.cc.Write(l.fixedLengthWriteBuffer)
+		n, err := writer.Write(report)
 		if err != nil {
 			return currentOffset, err
 		}
-		if n > 2 {
-			currentOffset += n - 2
+		// the whole report is written, padding included
+		if n > 2+toWriteLength {
+			n = 2 + toWriteLength
+		}
+		if n <= 2 {
+			// nothing of the frame was written, trying again could loop forever
+			return currentOffset, newCodecError(ErrShortWrite, "Short HID write: %d bytes of a %d bytes report", n, len(report))
 		}
+		currentOffset += n - 2
 	}
 	return currentOffset, nil
 }
@@ -0,0 +1,117 @@
+package uavtalk
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestWriteHIDReports(t *testing.T) {
+	for _, length := range []int{1, MaxHIDFrameSize - 2, MaxHIDFrameSize - 1, 3*(MaxHIDFrameSize-2) + 5} {
+		frame := make([]byte, length)
+		for i := range frame {
+			frame[i] = byte(i)
+		}
+		out := new(bytes.Buffer)
+		n, err := writeHIDReports(out, make([]byte, MaxHIDFrameSize), frame)
+		if err != nil || n != length {
+			t.Errorf("%d bytes: wrote %d %v", length, n, err)
+			continue
+		}
+
+		reports := out.Bytes()
+		if len(reports)%MaxHIDFrameSize != 0 {
+			t.Errorf("%d bytes: %d bytes of reports", length, len(reports))
+			continue
+		}
+		var payload []byte
+		for offset := 0; offset < len(reports); offset += MaxHIDFrameSize {
+			report := reports[offset : offset+MaxHIDFrameSize]
+			if report[0] != 0x02 || int(report[1]) > MaxHIDFrameSize-2 {
+				t.Errorf("%d bytes: report header %x", length, report[:2])
+			}
+			payload = append(payload, report[2:2+int(report[1])]...)
+		}
+		if bytes.Equal(payload, frame) == false {
+			t.Errorf("%d bytes: reports carry %x", length, payload)
+		}
+	}
+}
+
+// shortWriter accepts n bytes of each write
+type shortWriter struct {
+	n int
+}
+
+func (writer shortWriter) Write(b []byte) (int, error) {
+	return writer.n, nil
+}
+
+func TestWriteHIDReportsShortWrite(t *testing.T) {
+	frame := make([]byte, 3*(MaxHIDFrameSize-2))
+	for _, accepted := range []int{0, 1, 2} {
+		if n, err := writeHIDReports(shortWriter{accepted}, make([]byte, MaxHIDFrameSize), frame); ErrorKind(err) != ErrShortWrite || n != 0 {
+			t.Errorf("%d bytes accepted: wrote %d %v", accepted, n, err)
+		}
+	}
+	// reports partly written are completed by the next ones
+	if n, err := writeHIDReports(shortWriter{10}, make([]byte, MaxHIDFrameSize), frame); err != nil || n != len(frame) {
+		t.Errorf("10 bytes accepted: wrote %d %v", n, err)
+	}
+}
+
+// reportRecorder is a hidDevice keeping the reports written
+type reportRecorder struct {
+	lock    sync.Mutex
+	reports [][]byte
+}
+
+func (device *reportRecorder) Write(b []byte) (int, error) {
+	device.lock.Lock()
+	device.reports = append(device.reports, append([]byte(nil), b...))
+	device.lock.Unlock()
+	// give the other writers a chance to get in between
+	runtime.Gosched()
+	return len(b), nil
+}
+
+func (device *reportRecorder) ReadTimeout(b []byte, timeout int) (int, error) {
+	return 0, nil
+}
+
+func (device *reportRecorder) Close() {}
+
+func TestUSBLinkConcurrentWrites(t *testing.T) {
+	device := &reportRecorder{}
+	link := usbLink{device, make([]byte, MaxHIDFrameSize), &sync.Mutex{}}
+	const writers, frames, reportsPerFrame = 4, 20, 4
+
+	var wg sync.WaitGroup
+	for w := 1; w <= writers; w++ {
+		frame := bytes.Repeat([]byte{byte(w)}, reportsPerFrame*(MaxHIDFrameSize-2))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < frames; i++ {
+				if _, err := link.Write(frame); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(device.reports) != writers*frames*reportsPerFrame {
+		t.Fatalf("%d reports written", len(device.reports))
+	}
+	// the reports of a frame follow each other
+	for i := 0; i < len(device.reports); i += reportsPerFrame {
+		writer := device.reports[i][2]
+		for _, report := range device.reports[i : i+reportsPerFrame] {
+			if bytes.Count(report[2:], []byte{writer}) != MaxHIDFrameSize-2 {
+				t.Fatalf("report %d: frames of writers %d and %d interleaved", i, writer, report[2])
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package uavtalk
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/**
+ * Bytes read from and written to the link are counted, and sampled every second by start,
+ * rates are computed over the samples of the last RateWindow.
+ */
+
+// RateWindow is the duration over which the link rates are averaged
+var RateWindow = 10 * time.Second
+
+// LinkRates is the throughput of the link in bytes per second, measured locally,
+// along with the rates reported by the board in FlightTelemetryStats when received.
+type LinkRates struct {
+	InBytesPerSecond  float64
+	OutBytesPerSecond float64
+
+	BoardTxDataRate float64
+	BoardRxDataRate float64
+}
+
+type rateSample struct {
+	at      time.Time
+	in, out uint64
+}
+
+var bytesIn, bytesOut uint64
+
+var rateSamplesLock sync.Mutex
+var rateSamples []rateSample
+
+// LinkBytes returns the number of bytes read from and written to the link since the start
+func LinkBytes() (uint64, uint64) {
+	return atomic.LoadUint64(&bytesIn), atomic.LoadUint64(&bytesOut)
+}
+
+// GetLinkRates returns the current link rates
+func GetLinkRates() LinkRates {
+	rates := LinkRates{}
+
+	rateSamplesLock.Lock()
+	if len(rateSamples) > 1 {
+		first, last := rateSamples[0], rateSamples[len(rateSamples)-1]
+		elapsed := last.at.Sub(first.at).Seconds()
+		rates.InBytesPerSecond = float64(last.in-first.in) / elapsed
+		rates.OutBytesPerSecond = float64(last.out-first.out) / elapsed
+	}
+	rateSamplesLock.Unlock()
+
+	if definition, err := AllDefinitions.GetDefinitionForName("FlightTelemetryStats"); err == nil {
+		if data, ok := LastValues.Get(definition.ObjectID, 0); ok {
+			if rate, ok := data["TxDataRate"].(float32); ok {
+				rates.BoardTxDataRate = float64(rate)
+			}
+			if rate, ok := data["RxDataRate"].(float32); ok {
+				rates.BoardRxDataRate = float64(rate)
+			}
+		}
+	}
+	return rates
+}
+
+// sampleLinkRates records the byte counters at now, dropping the samples older than RateWindow
+func sampleLinkRates(now time.Time) {
+	in, out := LinkBytes()
+
+	rateSamplesLock.Lock()
+	defer rateSamplesLock.Unlock()
+	kept := rateSamples[:0]
+	for _, sample := range rateSamples {
+		if now.Sub(sample.at) <= RateWindow {
+			kept = append(kept, sample)
+		}
+	}
+	rateSamples = append(kept, rateSample{now, in, out})
+}
@@ -0,0 +1,36 @@
+package uavtalk
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLinkRates(t *testing.T) {
+	loadTestDefinitions(t)
+	defer func() { rateSamples = nil }()
+	rateSamples = nil
+
+	start := time.Unix(1000, 0)
+	for i := 0; i < 15; i++ {
+		atomic.AddUint64(&bytesIn, 1000)
+		atomic.AddUint64(&bytesOut, 100)
+		sampleLinkRates(start.Add(time.Duration(i) * time.Second))
+	}
+	// only the samples of the last RateWindow are kept
+	if len(rateSamples) != 11 {
+		t.Errorf("%d samples kept", len(rateSamples))
+	}
+
+	LastValues.Update(NewPacket(AllDefinitions.MustGetDefinitionForName("FlightTelemetryStats"), ObjectCmd, 0, map[string]interface{}{
+		"TxDataRate": float32(1200), "RxDataRate": float32(300),
+	}))
+	rates := GetLinkRates()
+	if math.Abs(rates.InBytesPerSecond-1000) > 1e-9 || math.Abs(rates.OutBytesPerSecond-100) > 1e-9 {
+		t.Errorf("got rates %+v", rates)
+	}
+	if rates.BoardTxDataRate != 1200 || rates.BoardRxDataRate != 300 {
+		t.Errorf("got board rates %+v", rates)
+	}
+}
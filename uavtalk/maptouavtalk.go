@@ -5,6 +5,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
 )
 
 func valueForEnumString(field *FieldDefinition, option string) (uint8, error) {
@@ -16,17 +18,65 @@ func valueForEnumString(field *FieldDefinition, option string) (uint8, error) {
 	return 0, fmt.Errorf("%s enum option not found", option)
 }
 
-func writeToUAVTalk(field *FieldDefinition, writer *bytes.Buffer, value interface{}) error {
+// valueForEnum accepts an enum value as an option name, an index, an index as a numeric string,
+// or a bool for boolean like enums.
+// A numeric string naming one option while being the index of another one is rejected as ambiguous.
+func valueForEnum(field *FieldDefinition, value interface{}) (uint8, error) {
+	var index float64
+	switch v := value.(type) {
+	case string:
+		byName, nameErr := valueForEnumString(field, v)
+		byIndex, indexErr := strconv.ParseUint(v, 10, 8)
+		if nameErr == nil && indexErr == nil && uint64(byName) != byIndex && byIndex < uint64(len(field.Options)) {
+			return 0, fmt.Errorf("%s: enum value %q is ambiguous, option %d or index %d", field.Name, v, byName, byIndex)
+		}
+		if nameErr == nil {
+			return byName, nil
+		}
+		if indexErr != nil {
+			return 0, fmt.Errorf("%s: %q is neither an enum option nor an index", field.Name, v)
+		}
+		index = float64(byIndex)
+	case bool:
+		falseIndex, trueIndex, ok := booleanEnumIndexes(field)
+		if ok == false {
+			return 0, fmt.Errorf("%s: options %v are not boolean like, can't take %v", field.Name, field.Options, v)
+		}
+		if v {
+			return trueIndex, nil
+		}
+		return falseIndex, nil
+	case float64:
+		index = v
+	case int:
+		index = float64(v)
+	case uint8:
+		index = float64(v)
+	default:
+		return 0, fmt.Errorf("%s: wrong enum value %v (%T)", field.Name, value, value)
+	}
+
+	if index < 0 || index >= float64(len(field.Options)) || index != math.Floor(index) {
+		return 0, fmt.Errorf("%s: %v is not the index of one of the %d enum options", field.Name, index, len(field.Options))
+	}
+	if index > math.MaxUint8 {
+		return 0, fmt.Errorf("%s: enum index %v doesn't fit in 1 byte", field.Name, index)
+	}
+	return uint8(index), nil
+}
+
+func writeToUAVTalk(field *FieldDefinition, writer *bytes.Buffer, value interface{}) (err error) {
 	defer func() {
+		// the type assertions below panic on values of the wrong type
 		if r := recover(); r != nil {
-			fmt.Println("Recovered in f", r)
+			err = fmt.Errorf("%s: wrong value %v (%T)", field.Name, value, value)
 		}
 	}()
 	typeInfo := field.FieldTypeInfo
 	var result interface{}
 	switch typeInfo.Name {
 	case "int8":
-		result = uint8(value.(float64))
+		result = int8(value.(float64))
 	case "int16":
 		result = int16(value.(float64))
 	case "int32":
@@ -41,7 +91,7 @@ func writeToUAVTalk(field *FieldDefinition, writer *bytes.Buffer, value interfac
 		result = float32(value.(float64))
 	case "enum":
 		var err error
-		if result, err = valueForEnumString(field, value.(string)); err != nil {
+		if result, err = valueForEnum(field, value); err != nil {
 			return err
 		}
 	}
@@ -56,12 +106,37 @@ func writeToUAVTalk(field *FieldDefinition, writer *bytes.Buffer, value interfac
 	return nil
 }
 
+// writeStringToUAVTalk writes a string field NUL padded to its capacity,
+// a string using the whole capacity has no terminating NUL.
+func writeStringToUAVTalk(field *FieldDefinition, writer *bytes.Buffer, value interface{}) error {
+	s, ok := value.(string)
+	if ok == false {
+		return fmt.Errorf("Value for %s should be a string", field.Name)
+	}
+	if len(s) > field.Elements {
+		return fmt.Errorf("Value for %s is %d bytes long, capacity is %d", field.Name, len(s), field.Elements)
+	}
+	writer.WriteString(s)
+	for i := len(s); i < field.Elements; i++ {
+		writer.WriteByte(0)
+	}
+	return nil
+}
+
 func interfaceToUAVTalk(field *FieldDefinition, writer *bytes.Buffer, value interface{}) error {
+	if field.FieldTypeInfo.Name == "string" {
+		return writeStringToUAVTalk(field, writer, value)
+	}
+
 	if field.Elements > 1 && len(field.ElementNames) == 0 {
 		valueArray, ok := value.([]interface{})
 
 		if ok == false {
-			return fmt.Errorf("Value for %s should be a map of fields with Elements > 1", field.Name)
+			return fmt.Errorf("Value for %s should be an array of %d elements", field.Name, field.Elements)
+		}
+		// each element is encoded on its own, a shorter or longer array would shift the following fields
+		if len(valueArray) != field.Elements {
+			return fmt.Errorf("Value for %s has %d elements instead of %d", field.Name, len(valueArray), field.Elements)
 		}
 
 		for _, value := range valueArray {
@@ -92,11 +167,18 @@ func interfaceToUAVTalk(field *FieldDefinition, writer *bytes.Buffer, value inte
 
 func mapToUAVTalk(uavdef *Definition, data map[string]interface{}) ([]byte, error) {
 	writer := new(bytes.Buffer)
+	if err := writeMapToUAVTalk(uavdef, data, writer); err != nil {
+		return nil, err
+	}
+
+	return writer.Bytes(), nil
+}
+
+func writeMapToUAVTalk(uavdef *Definition, data map[string]interface{}, writer *bytes.Buffer) error {
 	for _, field := range uavdef.Fields {
 		if err := interfaceToUAVTalk(field, writer, data[field.Name]); err != nil {
-			return nil, err
+			return err
 		}
 	}
-
-	return writer.Bytes(), nil
+	return nil
 }
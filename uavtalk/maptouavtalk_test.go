@@ -0,0 +1,124 @@
+package uavtalk
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestValueForEnum(t *testing.T) {
+	field := &FieldDefinition{Name: "Mode", Options: []string{"Off", "2", "On"}}
+	boolean := &FieldDefinition{Name: "Armed", Options: []string{"Enabled", "Disabled"}}
+
+	tests := []struct {
+		field    *FieldDefinition
+		value    interface{}
+		expected uint8
+		ok       bool
+	}{
+		{field, "Off", 0, true},
+		{field, "On", 2, true},
+		{field, "0", 0, true},
+		{field, float64(2), 2, true},
+		{field, int(1), 1, true},
+		{field, uint8(1), 1, true},
+		{field, "Auto", 0, false},
+		{field, float64(3), 0, false},
+		{field, float64(-1), 0, false},
+		{field, float64(1.5), 0, false},
+		// option "2" is at index 1
+		{field, "2", 0, false},
+		{field, true, 0, false},
+		{field, int32(1), 0, false},
+		{boolean, true, 0, true},
+		{boolean, false, 1, true},
+	}
+
+	for _, test := range tests {
+		value, err := valueForEnum(test.field, test.value)
+		if (err == nil) != test.ok || (test.ok && value != test.expected) {
+			t.Errorf("%s %v (%T): got %d %v, expected %d ok %t", test.field.Name, test.value, test.value, value, err, test.expected, test.ok)
+		}
+	}
+}
+
+func TestMapToUAVTalkErrors(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+
+	tests := []struct {
+		field string
+		value interface{}
+	}{
+		{"Counter", []interface{}{float64(1)}},
+		{"Counter", []interface{}{float64(1), float64(2), float64(3)}},
+		{"Counter", float64(1)},
+		{"Position", []interface{}{float64(1), float64(2), float64(3)}},
+		{"Velocity", "fast"},
+		{"Sign", nil},
+		{"Modes", []interface{}{"On", "Off", "On", "Auto"}},
+	}
+
+	for _, test := range tests {
+		data := waypointData()
+		data[test.field] = test.value
+		if _, err := mapToUAVTalk(definition, data); err == nil {
+			t.Errorf("%s = %v: encoded without error", test.field, test.value)
+		}
+		if err := definition.ValidateData(data); err == nil {
+			t.Errorf("%s = %v: validated", test.field, test.value)
+		}
+	}
+
+	if err := definition.ValidateData(waypointData()); err != nil {
+		t.Error(err)
+	}
+	data := waypointData()
+	delete(data, "Sign")
+	if err := definition.ValidateData(data); err == nil {
+		t.Error("validated without the Sign field")
+	}
+	data = waypointData()
+	data["Heading"] = float64(0)
+	if err := definition.ValidateData(data); err == nil {
+		t.Error("validated with an unknown field")
+	}
+}
+
+func TestWriteToUAVTalkWrongTypes(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+
+	// values of the wrong type used to panic on the type assertions
+	tests := []struct {
+		field string
+		value interface{}
+	}{
+		{"Velocity", "fast"},
+		{"Velocity", nil},
+		{"Distance", int32(1)},
+		{"Sign", []interface{}{float64(1)}},
+		{"Counter", map[string]interface{}{}},
+	}
+	for _, test := range tests {
+		field, err := definition.Fields.FieldForName(test.field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeToUAVTalk(field, new(bytes.Buffer), test.value); err == nil {
+			t.Errorf("%s = %v (%T): written without error", test.field, test.value, test.value)
+		}
+	}
+}
+
+func TestMustGetDefinitionForName(t *testing.T) {
+	loadTestDefinitions(t)
+	if definition := AllDefinitions.MustGetDefinitionForName("waypoint"); definition.Name != "Waypoint" {
+		t.Errorf("got %s", definition.Name)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for an unknown object")
+		}
+	}()
+	AllDefinitions.MustGetDefinitionForName("Unknown")
+}
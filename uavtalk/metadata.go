@@ -0,0 +1,221 @@
+package uavtalk
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/**
+ * Meta objects carry the telemetry settings of their parent object,
+ * see UAVObjMetadata in Taulabs' flight/UAVObjects/inc/uavobjectmanager.h for the layout.
+ */
+
+// Metadata flags bit offsets in the modes field
+const (
+	metaFlightAccessShift          = 0
+	metaGcsAccessShift             = 1
+	metaFlightTelemetryAckedShift  = 2
+	metaGcsTelemetryAckedShift     = 3
+	metaFlightTelemetryUpdateShift = 4
+	metaGcsTelemetryUpdateShift    = 6
+	metaUpdateModeMask             = 0x3
+)
+
+// UpdateMode is the way an object is sent over telemetry
+type UpdateMode uint8
+
+// Update modes, in the order used by the flight controller
+const (
+	UpdateModeManual UpdateMode = iota
+	UpdateModePeriodic
+	UpdateModeOnChange
+	UpdateModeThrottled
+)
+
+var updateModeNames = []string{"manual", "periodic", "onchange", "throttled"}
+
+func (mode UpdateMode) String() string {
+	if int(mode) < len(updateModeNames) {
+		return updateModeNames[mode]
+	}
+	return fmt.Sprintf("UpdateMode(%d)", uint8(mode))
+}
+
+// UpdateModeForString returns the UpdateMode for its name as found in the xml definitions
+func UpdateModeForString(s string) (UpdateMode, error) {
+	if len(s) == 0 {
+		return UpdateModeManual, nil
+	}
+	for mode, name := range updateModeNames {
+		if name == s {
+			return UpdateMode(mode), nil
+		}
+	}
+	return UpdateModeManual, fmt.Errorf("Not found update mode: %s", s)
+}
+
+// Metadata is the decoded content of a meta object
+type Metadata struct {
+	FlightReadOnly            bool
+	GcsReadOnly               bool
+	FlightTelemetryAcked      bool
+	GcsTelemetryAcked         bool
+	FlightTelemetryUpdateMode UpdateMode
+	GcsTelemetryUpdateMode    UpdateMode
+	FlightTelemetryPeriod     uint16
+	GcsTelemetryPeriod        uint16
+	LoggingPeriod             uint16
+}
+
+// NewMetadataFromDefinition returns the Metadata described in the xml definition of an object
+func NewMetadataFromDefinition(definition *Definition) (*Metadata, error) {
+	if definition.MetaFor != nil {
+		return nil, fmt.Errorf("%s is a meta definition", definition.Name)
+	}
+
+	var err error
+	metadata := &Metadata{}
+	metadata.FlightReadOnly = definition.Access.Flight == "readonly"
+	metadata.GcsReadOnly = definition.Access.Gcs == "readonly"
+	metadata.FlightTelemetryAcked = definition.TelemetryFlight.Acked
+	metadata.GcsTelemetryAcked = definition.TelemetryGcs.Acked
+
+	if metadata.FlightTelemetryUpdateMode, err = UpdateModeForString(definition.TelemetryFlight.UpdateMode); err != nil {
+		return nil, err
+	}
+	if metadata.GcsTelemetryUpdateMode, err = UpdateModeForString(definition.TelemetryGcs.UpdateMode); err != nil {
+		return nil, err
+	}
+
+	if metadata.FlightTelemetryPeriod, err = parsePeriod(definition.TelemetryFlight.Period); err != nil {
+		return nil, err
+	}
+	if metadata.GcsTelemetryPeriod, err = parsePeriod(definition.TelemetryGcs.Period); err != nil {
+		return nil, err
+	}
+	if metadata.LoggingPeriod, err = parsePeriod(definition.Logging.Period); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// NewMetadataFromMap decodes the Data of a packet received for a meta object
+func NewMetadataFromMap(data map[string]interface{}) (*Metadata, error) {
+	modes, ok := data["modes"].(uint8)
+	if ok == false {
+		return nil, fmt.Errorf("Meta field modes missing or not an uint8")
+	}
+
+	periods := make([]uint16, 3)
+	for i, name := range []string{"periodFlight", "periodGCS", "periodLog"} {
+		period, ok := data[name].(uint16)
+		if ok == false {
+			return nil, fmt.Errorf("Meta field %s missing or not an uint16", name)
+		}
+		periods[i] = period
+	}
+
+	metadata := &Metadata{}
+	metadata.FlightReadOnly = modes&(1<<metaFlightAccessShift) != 0
+	metadata.GcsReadOnly = modes&(1<<metaGcsAccessShift) != 0
+	metadata.FlightTelemetryAcked = modes&(1<<metaFlightTelemetryAckedShift) != 0
+	metadata.GcsTelemetryAcked = modes&(1<<metaGcsTelemetryAckedShift) != 0
+	metadata.FlightTelemetryUpdateMode = UpdateMode((modes >> metaFlightTelemetryUpdateShift) & metaUpdateModeMask)
+	metadata.GcsTelemetryUpdateMode = UpdateMode((modes >> metaGcsTelemetryUpdateShift) & metaUpdateModeMask)
+	metadata.FlightTelemetryPeriod = periods[0]
+	metadata.GcsTelemetryPeriod = periods[1]
+	metadata.LoggingPeriod = periods[2]
+	return metadata, nil
+}
+
+// Modes returns the packed flags byte of the meta object
+func (metadata *Metadata) Modes() uint8 {
+	var modes uint8
+	if metadata.FlightReadOnly {
+		modes |= 1 << metaFlightAccessShift
+	}
+	if metadata.GcsReadOnly {
+		modes |= 1 << metaGcsAccessShift
+	}
+	if metadata.FlightTelemetryAcked {
+		modes |= 1 << metaFlightTelemetryAckedShift
+	}
+	if metadata.GcsTelemetryAcked {
+		modes |= 1 << metaGcsTelemetryAckedShift
+	}
+	modes |= (uint8(metadata.FlightTelemetryUpdateMode) & metaUpdateModeMask) << metaFlightTelemetryUpdateShift
+	modes |= (uint8(metadata.GcsTelemetryUpdateMode) & metaUpdateModeMask) << metaGcsTelemetryUpdateShift
+	return modes
+}
+
+// ToMap returns the metadata as the Data of a packet for the meta object
+func (metadata *Metadata) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"modes":        float64(metadata.Modes()),
+		"periodFlight": float64(metadata.FlightTelemetryPeriod),
+		"periodGCS":    float64(metadata.GcsTelemetryPeriod),
+		"periodLog":    float64(metadata.LoggingPeriod),
+	}
+}
+
+// CreateMetadataSetter returns a packet setting the metadata of the object with the given name
+func CreateMetadataSetter(name string, metadata *Metadata) (Packet, error) {
+	meta, err := metaDefinitionForName(name)
+	if err != nil {
+		return Packet{}, err
+	}
+	return *NewPacket(meta, ObjectCmd, 0, metadata.ToMap()), nil
+}
+
+// CreateMetadataRequest returns a packet requesting the meta object of the object with the given name,
+// the Data of the reply can be decoded with NewMetadataFromMap.
+func CreateMetadataRequest(name string) (Packet, error) {
+	meta, err := metaDefinitionForName(name)
+	if err != nil {
+		return Packet{}, err
+	}
+	return *NewPacket(meta, ObjectRequest, 0, map[string]interface{}{}), nil
+}
+
+// CreateFlightTelemetrySetter returns a packet changing how the flight controller sends the object with the given name,
+// other metadata are the ones from the xml definition.
+func CreateFlightTelemetrySetter(name string, mode UpdateMode, period uint16) (Packet, error) {
+	definition, err := AllDefinitions.GetDefinitionForName(name)
+	if err != nil {
+		return Packet{}, err
+	}
+	metadata, err := NewMetadataFromDefinition(definition)
+	if err != nil {
+		return Packet{}, err
+	}
+	metadata.FlightTelemetryUpdateMode = mode
+	metadata.FlightTelemetryPeriod = period
+	return CreateMetadataSetter(name, metadata)
+}
+
+// metaDefinitionForName returns the meta definition paired with the object with the given name,
+// definitions are shared once loaded so a missing one is not created here.
+func metaDefinitionForName(name string) (*Definition, error) {
+	definition, err := AllDefinitions.GetDefinitionForName(name)
+	if err != nil {
+		return nil, err
+	}
+	if definition.MetaFor != nil {
+		return nil, fmt.Errorf("%s is a meta definition", definition.Name)
+	}
+	if definition.Meta == nil {
+		return nil, fmt.Errorf("%s has no meta definition, it was not loaded with LoadDefinitions or RegisterDefinition", definition.Name)
+	}
+	return definition.Meta, nil
+}
+
+func parsePeriod(s string) (uint16, error) {
+	if len(s) == 0 {
+		return 0, nil
+	}
+	period, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("Wrong period %s: %s", s, err)
+	}
+	return uint16(period), nil
+}
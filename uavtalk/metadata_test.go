@@ -0,0 +1,124 @@
+package uavtalk
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMetadataModes(t *testing.T) {
+	tests := []Metadata{
+		{},
+		{FlightReadOnly: true, GcsTelemetryAcked: true, FlightTelemetryUpdateMode: UpdateModePeriodic, FlightTelemetryPeriod: 100},
+		{GcsReadOnly: true, FlightTelemetryAcked: true, GcsTelemetryUpdateMode: UpdateModeThrottled, GcsTelemetryPeriod: 65535, LoggingPeriod: 1000},
+		{FlightTelemetryUpdateMode: UpdateModeOnChange, GcsTelemetryUpdateMode: UpdateModeOnChange},
+	}
+	for _, metadata := range tests {
+		data := map[string]interface{}{}
+		for name, value := range metadata.ToMap() {
+			// as decoded from a frame
+			if name == "modes" {
+				data[name] = uint8(value.(float64))
+			} else {
+				data[name] = uint16(value.(float64))
+			}
+		}
+		decoded, err := NewMetadataFromMap(data)
+		if err != nil || *decoded != metadata {
+			t.Errorf("%+v decoded as %+v %v", metadata, decoded, err)
+		}
+	}
+
+	if _, err := NewMetadataFromMap(map[string]interface{}{"modes": uint8(0)}); err == nil {
+		t.Error("decoded without the periods")
+	}
+}
+
+func TestNewMetadataFromDefinition(t *testing.T) {
+	loadTestDefinitions(t)
+
+	metadata, err := NewMetadataFromDefinition(AllDefinitions.MustGetDefinitionForName("AttitudeActual"))
+	expected := Metadata{FlightTelemetryUpdateMode: UpdateModePeriodic, FlightTelemetryPeriod: 100}
+	if err != nil || *metadata != expected {
+		t.Errorf("got %+v %v", metadata, err)
+	}
+	metadata, err = NewMetadataFromDefinition(AllDefinitions.MustGetDefinitionForName("Waypoint"))
+	expected = Metadata{
+		FlightTelemetryAcked: true, GcsTelemetryAcked: true,
+		FlightTelemetryUpdateMode: UpdateModeOnChange, GcsTelemetryUpdateMode: UpdateModeOnChange,
+	}
+	if err != nil || *metadata != expected {
+		t.Errorf("got %+v %v", metadata, err)
+	}
+	if _, err := NewMetadataFromDefinition(AllDefinitions.MustGetDefinitionForName("WaypointMeta")); err == nil {
+		t.Error("metadata of a meta definition")
+	}
+}
+
+func TestCreateMetadataRequest(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+
+	request, err := CreateMetadataRequest("Waypoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Definition != definition.Meta || request.Cmd != ObjectRequest || request.ObjectID != MetaObjectID(definition.ObjectID) {
+		t.Errorf("request for %s cmd %d", request.Definition.Name, request.Cmd)
+	}
+	if _, err := request.toBinary(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the board replies with the meta object
+	sent := Metadata{GcsTelemetryAcked: true, FlightTelemetryUpdateMode: UpdateModeThrottled, FlightTelemetryPeriod: 250}
+	reply, err := CreateMetadataSetter("Waypoint", &sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := reply.toBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := newPacketFromBinary(frame, FrameChecksum.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := NewMetadataFromMap(decoded.Data)
+	if decoded.Definition != definition.Meta || err != nil || *metadata != sent {
+		t.Errorf("reply decoded for %s as %+v %v", decoded.Definition.Name, metadata, err)
+	}
+
+	setter, err := CreateFlightTelemetrySetter("Waypoint", UpdateModePeriodic, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Metadata{
+		FlightTelemetryAcked: true, GcsTelemetryAcked: true,
+		FlightTelemetryUpdateMode: UpdateModePeriodic, FlightTelemetryPeriod: 500, GcsTelemetryUpdateMode: UpdateModeOnChange,
+	}
+	if setter.Definition != definition.Meta || reflect.DeepEqual(setter.Data, expected.ToMap()) == false {
+		t.Errorf("setter for %s with %v", setter.Definition.Name, setter.Data)
+	}
+}
+
+func TestCreateMetadataErrors(t *testing.T) {
+	loadTestDefinitions(t)
+	// a definition without its meta definition, which must not be created on the shared definition
+	orphan := AllDefinitions.MustGetDefinitionForName("Waypoint").Clone()
+	setDefinitions(Definitions{orphan})
+
+	for _, name := range []string{"Waypoint", "Unknown"} {
+		if _, err := CreateMetadataRequest(name); err == nil {
+			t.Errorf("%s: request created", name)
+		}
+		if _, err := CreateMetadataSetter(name, &Metadata{}); err == nil {
+			t.Errorf("%s: setter created", name)
+		}
+		if _, err := CreateFlightTelemetrySetter(name, UpdateModePeriodic, 100); err == nil {
+			t.Errorf("%s: flight telemetry setter created", name)
+		}
+	}
+	if orphan.Meta != nil {
+		t.Error("meta definition created on a loaded definition")
+	}
+}
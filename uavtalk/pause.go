@@ -0,0 +1,61 @@
+package uavtalk
+
+import "sync"
+
+var pauseLock sync.Mutex
+
+// resumeChan is closed on resume, nil while sending is not paused
+var resumeChan chan struct{}
+
+// pausedChan wakes up the link writer waiting for a packet, so it pauses at once
+var pausedChan = make(chan struct{}, 1)
+
+// PauseSending stops the link writer from taking packets from inChan, which then buffers up to its capacity:
+// once it is full, whoever sends to inChan blocks until ResumeSending, as do SendBatch and SendRaw.
+// The Client acks are dropped instead, the board sending the objects again, so its dispatch never blocks.
+// The link stays open and keeps being read.
+func PauseSending() {
+	pauseLock.Lock()
+	defer pauseLock.Unlock()
+	if resumeChan == nil {
+		resumeChan = make(chan struct{})
+		select {
+		case pausedChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ResumeSending lets the link writer send again, starting with what was buffered while paused
+func ResumeSending() {
+	pauseLock.Lock()
+	defer pauseLock.Unlock()
+	if resumeChan != nil {
+		close(resumeChan)
+		resumeChan = nil
+	}
+}
+
+// SendingPaused tells whether PauseSending is in effect
+func SendingPaused() bool {
+	pauseLock.Lock()
+	defer pauseLock.Unlock()
+	return resumeChan != nil
+}
+
+// waitSendingResumed blocks while sending is paused, it returns false if quit is closed in the meantime
+func waitSendingResumed(quit chan struct{}) bool {
+	pauseLock.Lock()
+	resumed := resumeChan
+	pauseLock.Unlock()
+	if resumed == nil {
+		return true
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-quit:
+		return false
+	}
+}
@@ -0,0 +1,80 @@
+package uavtalk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPauseSending(t *testing.T) {
+	quit := make(chan struct{})
+	if waitSendingResumed(quit) == false {
+		t.Fatal("not resumed while not paused")
+	}
+
+	PauseSending()
+	PauseSending()
+	if SendingPaused() == false {
+		t.Fatal("not paused")
+	}
+	select {
+	case <-pausedChan:
+	default:
+		t.Error("the writer was not woken up")
+	}
+
+	resumed := make(chan bool)
+	go func() { resumed <- waitSendingResumed(quit) }()
+	select {
+	case <-resumed:
+		t.Fatal("resumed while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+	ResumeSending()
+	ResumeSending()
+	if <-resumed == false || SendingPaused() {
+		t.Error("not resumed")
+	}
+
+	PauseSending()
+	defer ResumeSending()
+	<-pausedChan
+	go func() { resumed <- waitSendingResumed(quit) }()
+	close(quit)
+	if <-resumed {
+		t.Error("resumed when quitting")
+	}
+}
+
+func TestPausedClientAck(t *testing.T) {
+	loadTestDefinitions(t)
+	waypoint := AllDefinitions.MustGetDefinitionForName("Waypoint")
+	client, _ := newTestClient(true)
+	PauseSending()
+	defer ResumeSending()
+
+	// nothing is taken from inChan while paused, until it is full
+	for len(client.inChan) < cap(client.inChan) {
+		client.inChan <- *NewPacket(waypoint, ObjectRequest, 1, nil)
+	}
+	handled := make(chan struct{})
+	go func() {
+		client.handle(*NewPacket(waypoint, ObjectCmdWithAck, 2, waypointData()))
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch blocked acking with a full inChan")
+	}
+
+	// the ack was dropped, the next one gets through once there is room
+	for len(client.inChan) > 0 {
+		if packet := <-client.inChan; packet.Cmd == ObjectAck {
+			t.Fatal("ack queued in a full inChan")
+		}
+	}
+	client.handle(*NewPacket(waypoint, ObjectCmdWithAck, 2, waypointData()))
+	if ack := <-client.inChan; ack.Cmd != ObjectAck || ack.InstanceID != 2 {
+		t.Errorf("sent cmd %d instance %d", ack.Cmd, ack.InstanceID)
+	}
+}
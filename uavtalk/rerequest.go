@@ -0,0 +1,119 @@
+package uavtalk
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+/**
+ * Objects can be requested again when one of their frames is lost to a checksum or decode error,
+ * so their latest value is recovered instead of waiting for the next update, which may never come for onchange objects.
+ * The object id of a frame failing its checksum may itself be corrupted, only tracked objects are requested anyway.
+ * Requests are sent by the same goroutine as the polls.
+ */
+
+type rerequestPolicy struct {
+	definition *Definition
+	period     time.Duration
+
+	// last request of each instance
+	last map[uint16]time.Time
+	// instances to request on the next poll
+	pending map[uint16]bool
+}
+
+var rerequestMutex sync.Mutex
+var rerequestPolicies = map[uint32]*rerequestPolicy{}
+
+// RerequestOnError requests the object with the given name again when one of its frames fails its checksum
+// or can't be decoded, at most once per period and instance.
+func RerequestOnError(name string, period time.Duration) error {
+	if period <= 0 {
+		return fmt.Errorf("Wrong re-request period %s for %s", period, name)
+	}
+	definition, err := AllDefinitions.GetDefinitionForName(name)
+	if err != nil {
+		return err
+	}
+
+	rerequestMutex.Lock()
+	defer rerequestMutex.Unlock()
+	// as read from the header, which may not hold the whole id
+	rerequestPolicies[definition.ObjectID&Layout.objectIDMask()] = &rerequestPolicy{
+		definition: definition,
+		period:     period,
+		last:       map[uint16]time.Time{},
+		pending:    map[uint16]bool{},
+	}
+	return nil
+}
+
+// ClearRerequestOnError stops requesting the object with the given name on errors
+func ClearRerequestOnError(name string) {
+	definition, err := AllDefinitions.GetDefinitionForName(name)
+	if err != nil {
+		return
+	}
+	rerequestMutex.Lock()
+	defer rerequestMutex.Unlock()
+	delete(rerequestPolicies, definition.ObjectID&Layout.objectIDMask())
+}
+
+// rebindRerequestPolicies moves the policies to the definitions of defs, see ReloadDefinitions
+func rebindRerequestPolicies(defs Definitions) {
+	rerequestMutex.Lock()
+	defer rerequestMutex.Unlock()
+	for objectID, policy := range rerequestPolicies {
+		definition, err := Layout.definitionForObjectID(defs, objectID)
+		if err != nil {
+			log.Warningf("%s not found after reload, it is no longer requested on errors", policy.definition.Name)
+			delete(rerequestPolicies, objectID)
+			continue
+		}
+		policy.definition = definition
+	}
+}
+
+// frameFailed records a complete frame that failed its checksum or decoding,
+// its object instance is requested on the next poll when tracked and not requested within the period.
+func frameFailed(frame []byte, now time.Time) {
+	rerequestMutex.Lock()
+	defer rerequestMutex.Unlock()
+	if len(rerequestPolicies) == 0 {
+		return
+	}
+
+	policy, ok := rerequestPolicies[Layout.readObjectID(frame)]
+	if ok == false {
+		return
+	}
+	var instanceID uint16
+	if policy.definition.SingleInstance == false {
+		if len(frame) < Layout.length(false) {
+			return
+		}
+		instanceID = Layout.readInstanceID(frame)
+	}
+	if last, ok := policy.last[instanceID]; ok && now.Sub(last) < policy.period {
+		return
+	}
+	policy.last[instanceID] = now
+	policy.pending[instanceID] = true
+}
+
+// dueRerequests returns the requests of the object instances recorded by frameFailed since the last call
+func dueRerequests() []Packet {
+	rerequestMutex.Lock()
+	defer rerequestMutex.Unlock()
+	var due []Packet
+	for _, policy := range rerequestPolicies {
+		for instanceID := range policy.pending {
+			due = append(due, *NewPacket(policy.definition, ObjectRequest, instanceID, map[string]interface{}{}))
+			delete(policy.pending, instanceID)
+		}
+	}
+	return due
+}
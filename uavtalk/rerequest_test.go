@@ -0,0 +1,58 @@
+package uavtalk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRerequestOnError(t *testing.T) {
+	loadTestDefinitions(t)
+	defer ClearRerequestOnError("Waypoint")
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+
+	if err := RerequestOnError("Waypoint", 0); err == nil {
+		t.Error("re-requested with a 0 period")
+	}
+	if err := RerequestOnError("Waypoint", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1000, 0)
+	frame := func(name string, instanceID uint16) []byte {
+		return encodeTestPacket(t, name, ObjectRequest, instanceID, nil)
+	}
+	tests := []struct {
+		frame     []byte
+		at        time.Duration
+		instances []uint16
+	}{
+		{frame("Waypoint", 3), 0, []uint16{3}},
+		// within the period of the last request
+		{frame("Waypoint", 3), 500 * time.Millisecond, nil},
+		{frame("Waypoint", 4), 500 * time.Millisecond, []uint16{4}},
+		{frame("Waypoint", 3), time.Second, []uint16{3}},
+		// not tracked
+		{frame("AttitudeActual", 0), time.Second, nil},
+		// too short for the instance id
+		{frame("Waypoint", 5)[:Layout.length(true)], 2 * time.Second, nil},
+	}
+	for i, test := range tests {
+		frameFailed(test.frame, start.Add(test.at))
+		due := dueRerequests()
+		if len(due) != len(test.instances) {
+			t.Errorf("case %d: %d requests, expected %v", i, len(due), test.instances)
+			continue
+		}
+		for j, request := range due {
+			if request.Definition != definition || request.Cmd != ObjectRequest || request.InstanceID != test.instances[j] {
+				t.Errorf("case %d: got %s cmd %d instance %d", i, request.Definition.Name, request.Cmd, request.InstanceID)
+			}
+		}
+	}
+
+	ClearRerequestOnError("Waypoint")
+	frameFailed(frame("Waypoint", 3), start.Add(time.Hour))
+	if due := dueRerequests(); len(due) != 0 {
+		t.Errorf("%d requests after the policy was cleared", len(due))
+	}
+}
@@ -0,0 +1,119 @@
+package uavtalk
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+/**
+ * Sampling policies emulate an update mode on our side, whatever the board is configured to:
+ * a polled object is requested at a fixed period (useful for onchange objects),
+ * a downsampled object is forwarded at most once per period (useful for fast periodic objects).
+ */
+
+// pollResolution is how often polled objects are checked for being due
+const pollResolution = 100 * time.Millisecond
+
+type samplingPolicy struct {
+	definition *Definition
+	poll       bool
+	period     time.Duration
+
+	// next request of a polled object
+	next time.Time
+	// last forwarded update of a downsampled object, per instance
+	last map[uint16]time.Time
+}
+
+var samplingMutex sync.Mutex
+var samplingPolicies = map[uint32]*samplingPolicy{}
+
+// PollObject requests the object with the given name every period, instance 0 for multi instance objects
+func PollObject(name string, period time.Duration) error {
+	return setSamplingPolicy(name, &samplingPolicy{poll: true, period: period})
+}
+
+// DownsampleObject forwards at most one update per period and instance of the object with the given name,
+// updates that need an ack are always forwarded.
+func DownsampleObject(name string, period time.Duration) error {
+	return setSamplingPolicy(name, &samplingPolicy{period: period, last: map[uint16]time.Time{}})
+}
+
+// ClearSamplingPolicy removes the policy set for the object with the given name
+func ClearSamplingPolicy(name string) {
+	definition, err := AllDefinitions.GetDefinitionForName(name)
+	if err != nil {
+		return
+	}
+	samplingMutex.Lock()
+	defer samplingMutex.Unlock()
+	delete(samplingPolicies, definition.ObjectID)
+}
+
+func setSamplingPolicy(name string, policy *samplingPolicy) error {
+	if policy.period <= 0 {
+		return fmt.Errorf("Wrong sampling period %s for %s", policy.period, name)
+	}
+	definition, err := AllDefinitions.GetDefinitionForName(name)
+	if err != nil {
+		return err
+	}
+	policy.definition = definition
+
+	samplingMutex.Lock()
+	defer samplingMutex.Unlock()
+	samplingPolicies[definition.ObjectID] = policy
+	return nil
+}
+
+// rebindSamplingPolicies moves the policies to the definitions of defs, see ReloadDefinitions
+func rebindSamplingPolicies(defs Definitions) {
+	samplingMutex.Lock()
+	defer samplingMutex.Unlock()
+	for objectID, policy := range samplingPolicies {
+		definition, err := defs.GetDefinitionForObjectID(objectID)
+		if err != nil {
+			log.Warningf("%s not found after reload, its sampling policy is dropped", policy.definition.Name)
+			delete(samplingPolicies, objectID)
+			continue
+		}
+		policy.definition = definition
+	}
+}
+
+// downsampled tells whether the packet should be dropped by the downsample policy of its object
+func downsampled(packet *Packet, now time.Time) bool {
+	if packet.Definition == nil || packet.Cmd != ObjectCmd {
+		return false
+	}
+
+	samplingMutex.Lock()
+	defer samplingMutex.Unlock()
+	policy, ok := samplingPolicies[packet.Definition.ObjectID]
+	if ok == false || policy.poll {
+		return false
+	}
+	if last, ok := policy.last[packet.InstanceID]; ok && now.Sub(last) < policy.period {
+		return true
+	}
+	policy.last[packet.InstanceID] = now
+	return false
+}
+
+// duePolls returns the definitions of the polled objects that need to be requested now
+func duePolls(now time.Time) []*Definition {
+	samplingMutex.Lock()
+	defer samplingMutex.Unlock()
+	var due []*Definition
+	for _, policy := range samplingPolicies {
+		if policy.poll == false || now.Before(policy.next) {
+			continue
+		}
+		policy.next = now.Add(policy.period)
+		due = append(due, policy.definition)
+	}
+	return due
+}
@@ -0,0 +1,81 @@
+package uavtalk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDownsampleObject(t *testing.T) {
+	loadTestDefinitions(t)
+	defer ClearSamplingPolicy("Waypoint")
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+
+	if err := DownsampleObject("Waypoint", 0); err == nil {
+		t.Error("downsampled with a 0 period")
+	}
+	if err := DownsampleObject("Unknown", time.Second); err == nil {
+		t.Error("downsampled an unknown object")
+	}
+	if err := DownsampleObject("Waypoint", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1000, 0)
+	tests := []struct {
+		cmd        uint8
+		instanceID uint16
+		at         time.Duration
+		dropped    bool
+	}{
+		{ObjectCmd, 0, 0, false},
+		{ObjectCmd, 0, 500 * time.Millisecond, true},
+		// instances are downsampled on their own
+		{ObjectCmd, 1, 500 * time.Millisecond, false},
+		// as are updates needing an ack
+		{ObjectCmdWithAck, 0, 600 * time.Millisecond, false},
+		{ObjectCmd, 0, time.Second, false},
+		{ObjectCmd, 1, 1200 * time.Millisecond, true},
+	}
+	for i, test := range tests {
+		packet := NewPacket(definition, test.cmd, test.instanceID, nil)
+		if dropped := downsampled(packet, start.Add(test.at)); dropped != test.dropped {
+			t.Errorf("case %d: dropped %t, expected %t", i, dropped, test.dropped)
+		}
+	}
+	// unknown objects passed through are never dropped
+	if downsampled(&Packet{ObjectID: definition.ObjectID, Cmd: ObjectCmd}, start.Add(1200*time.Millisecond)) {
+		t.Error("dropped a packet without definition")
+	}
+
+	ClearSamplingPolicy("Waypoint")
+	if downsampled(NewPacket(definition, ObjectCmd, 0, nil), start) {
+		t.Error("dropped after the policy was cleared")
+	}
+}
+
+func TestPollObject(t *testing.T) {
+	loadTestDefinitions(t)
+	defer ClearSamplingPolicy("AttitudeActual")
+	definition := AllDefinitions.MustGetDefinitionForName("AttitudeActual")
+
+	if err := PollObject("AttitudeActual", 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1000, 0)
+	tests := []struct {
+		at  time.Duration
+		due bool
+	}{
+		{0, true},
+		{100 * time.Millisecond, false},
+		{500 * time.Millisecond, true},
+		{900 * time.Millisecond, false},
+		{2 * time.Second, true},
+	}
+	for _, test := range tests {
+		due := duePolls(start.Add(test.at))
+		if (len(due) == 1 && due[0] == definition) != test.due {
+			t.Errorf("at %s: due %v, expected %t", test.at, due, test.due)
+		}
+	}
+}
@@ -20,6 +20,39 @@ func CreateObjectSetter(name string, index int, data map[string]interface{}) *Pa
 	return packet
 }
 
+// NewUpdatePacket returns a packet setting an instance of the object with the given name,
+// settings are sent with ObjectCmdWithAck. The name and data are checked against the definitions.
+func NewUpdatePacket(name string, instanceID uint16, data map[string]interface{}) (*Packet, error) {
+	definition, err := AllDefinitions.GetDefinitionForName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkInstanceID(definition, instanceID); err != nil {
+		return nil, err
+	}
+	if err := definition.ValidateData(data); err != nil {
+		return nil, err
+	}
+
+	var cmd uint8 = ObjectCmd
+	if definition.Settings == true {
+		cmd = ObjectCmdWithAck
+	}
+	return NewPacket(definition, cmd, instanceID, data), nil
+}
+
+// NewRequestPacket returns a packet requesting an instance of the object with the given name
+func NewRequestPacket(name string, instanceID uint16) (*Packet, error) {
+	definition, err := AllDefinitions.GetDefinitionForName(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkInstanceID(definition, instanceID); err != nil {
+		return nil, err
+	}
+	return NewPacket(definition, ObjectRequest, instanceID, map[string]interface{}{}), nil
+}
+
 func CreateGCSTelemetryStatsObjectPacket(status string) Packet {
 	definition, err := AllDefinitions.GetDefinitionForName("GCSTelemetryStats")
 	if err != nil {
@@ -60,21 +93,45 @@ func CreateSessionManagingPacket(sessionID uint16, objectOfInterestIndex uint8)
 	return *packet
 }
 
-func CreatePersistObject(definition *Definition, instanceID uint16) Packet {
+// ObjectPersistence operations and selections, as named in the options of its fields
+const (
+	PersistenceLoad   = "Load"
+	PersistenceSave   = "Save"
+	PersistenceDelete = "Delete"
+
+	PersistenceSingleObject   = "SingleObject"
+	PersistenceAllSettings    = "AllSettings"
+	PersistenceAllMetaObjects = "AllMetaObjects"
+	PersistenceAllObjects     = "AllObjects"
+)
+
+// CreateObjectPersistencePacket returns the acked ObjectPersistence command applying operation to selection,
+// objectID and instanceID are only used by the board for PersistenceSingleObject.
+func CreateObjectPersistencePacket(operation string, selection string, objectID uint32, instanceID uint16) Packet {
 	objectPersistenceDefinition, err := AllDefinitions.GetDefinitionForName("ObjectPersistence")
 	if err != nil {
 		log.Fatal(err)
 	}
-	packet := NewPacket(objectPersistenceDefinition, ObjectCmdWithAck, instanceID, map[string]interface{}{
-		"ObjectID":   float64(definition.ObjectID),
+	packet := NewPacket(objectPersistenceDefinition, ObjectCmdWithAck, 0, map[string]interface{}{
+		"ObjectID":   float64(objectID),
 		"InstanceID": float64(instanceID),
-		"Selection":  "SingleObject",
-		"Operation":  "Save",
+		"Selection":  selection,
+		"Operation":  operation,
 	})
 	return *packet
 }
 
-func CreatePacketAck(definition *Definition) Packet {
-	packet := NewPacket(definition, ObjectAck, 0, map[string]interface{}{})
+func CreatePersistObject(definition *Definition, instanceID uint16) Packet {
+	return CreateObjectPersistencePacket(PersistenceSave, PersistenceSingleObject, definition.ObjectID, instanceID)
+}
+
+// CreateSaveAllSettings returns the packet saving all the settings objects to the board flash
+func CreateSaveAllSettings() Packet {
+	return CreateObjectPersistencePacket(PersistenceSave, PersistenceAllSettings, 0, 0)
+}
+
+// CreatePacketAck returns the ack of an instance of an object received with ObjectCmdWithAck
+func CreatePacketAck(definition *Definition, instanceID uint16) Packet {
+	packet := NewPacket(definition, ObjectAck, instanceID, map[string]interface{}{})
 	return *packet
 }
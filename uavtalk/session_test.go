@@ -0,0 +1,59 @@
+package uavtalk
+
+import "testing"
+
+func TestNewUpdatePacket(t *testing.T) {
+	loadTestDefinitions(t)
+
+	tests := []struct {
+		name       string
+		instanceID uint16
+		data       map[string]interface{}
+		cmd        uint8
+		ok         bool
+	}{
+		{"AttitudeActual", 0, attitudeData(), ObjectCmd, true},
+		{"Waypoint", 3, waypointData(), ObjectCmd, true},
+		// settings are acked
+		{"Label", 0, labelData(), ObjectCmdWithAck, true},
+		{"Label", 1, labelData(), 0, false},
+		{"Label", 0, waypointData(), 0, false},
+		{"Unknown", 0, attitudeData(), 0, false},
+	}
+	for _, test := range tests {
+		packet, err := NewUpdatePacket(test.name, test.instanceID, test.data)
+		if (err == nil) != test.ok {
+			t.Errorf("%s instance %d: got error %v", test.name, test.instanceID, err)
+			continue
+		}
+		if test.ok && (packet.Cmd != test.cmd || packet.InstanceID != test.instanceID) {
+			t.Errorf("%s instance %d: got cmd %d instance %d", test.name, test.instanceID, packet.Cmd, packet.InstanceID)
+		}
+	}
+
+	if _, err := NewRequestPacket("AttitudeActual", 2); err == nil {
+		t.Error("requested instance 2 of a single instance object")
+	}
+	if packet, err := NewRequestPacket("Waypoint", 2); err != nil || packet.Cmd != ObjectRequest || packet.InstanceID != 2 {
+		t.Errorf("request: got %v %v", packet, err)
+	}
+}
+
+func TestCreateObjectPersistencePacket(t *testing.T) {
+	loadTestDefinitions(t)
+	label := AllDefinitions.MustGetDefinitionForName("Label")
+
+	packet := CreatePersistObject(label, 0)
+	frame, err := packet.toBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := newPacketFromBinary(frame, FrameChecksum.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Cmd != ObjectCmdWithAck || decoded.Data["Operation"] != PersistenceSave || decoded.Data["Selection"] != PersistenceSingleObject ||
+		decoded.Data["ObjectID"] != label.ObjectID || decoded.Data["InstanceID"] != uint32(0) {
+		t.Errorf("decoded cmd %d %v", decoded.Cmd, decoded.Data)
+	}
+}
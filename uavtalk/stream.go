@@ -0,0 +1,119 @@
+package uavtalk
+
+import (
+	"io"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// StreamDecoder decodes the packets read from any byte stream (tcp, serial, capture file...),
+// framing and resync are done as on the link by an accumulator.
+// When HIDReports is set, the stream is made of HID reports, as written by a StreamEncoder with HIDReports set.
+type StreamDecoder struct {
+	// SkipChecksum disables the checksum verification, for trusted streams
+	SkipChecksum bool
+	// Checksum ending the frames, FrameChecksum by default
+	Checksum   Checksum
+	HIDReports bool
+
+	reader      io.Reader
+	accumulator *accumulator
+	readBuffer  []byte
+	// incomplete HID report, until the next read
+	report  []byte
+	packets []*Packet
+	err     error
+}
+
+// NewStreamDecoder returns a StreamDecoder reading from reader
+func NewStreamDecoder(reader io.Reader) *StreamDecoder {
+	return &StreamDecoder{
+		Checksum:    FrameChecksum,
+		reader:      reader,
+		accumulator: newOfflineAccumulator(),
+		readBuffer:  make([]byte, 4096),
+	}
+}
+
+// Next returns the next packet of the stream, reading as much as needed.
+// Once the reader fails, the remaining complete packets are returned, then its error (io.EOF at the end of the stream),
+// an incomplete trailing packet is dropped.
+func (decoder *StreamDecoder) Next() (*Packet, error) {
+	for len(decoder.packets) == 0 {
+		if decoder.err != nil {
+			return nil, decoder.err
+		}
+
+		n, err := decoder.reader.Read(decoder.readBuffer)
+		if n > 0 {
+			decoder.accumulator.skipChecksum = decoder.SkipChecksum
+			decoder.accumulator.checksum = decoder.Checksum
+			if decoder.HIDReports {
+				decoder.writeReports(decoder.readBuffer[:n])
+			} else {
+				decoder.accumulator.write(decoder.readBuffer[:n])
+			}
+			resyncErr := decoder.accumulator.readPackets(func(packet *Packet) {
+				decoder.packets = append(decoder.packets, packet)
+			})
+			if resyncErr != nil {
+				// the accumulator has been flushed, decoding resumes at the next sync byte
+				log.Warning(resyncErr)
+			}
+		}
+		if err != nil {
+			decoder.err = err
+		}
+	}
+
+	packet := decoder.packets[0]
+	decoder.packets = decoder.packets[1:]
+	return packet, nil
+}
+
+// writeReports passes the payload of the whole HID reports read so far to the accumulator
+func (decoder *StreamDecoder) writeReports(b []byte) {
+	decoder.report = append(decoder.report, b...)
+	offset := 0
+	for ; len(decoder.report)-offset >= MaxHIDFrameSize; offset += MaxHIDFrameSize {
+		report := decoder.report[offset : offset+MaxHIDFrameSize]
+		length := int(report[1])
+		if length > MaxHIDFrameSize-2 {
+			length = MaxHIDFrameSize - 2
+		}
+		decoder.accumulator.write(report[2 : 2+length])
+	}
+	n := copy(decoder.report, decoder.report[offset:])
+	decoder.report = decoder.report[:n]
+}
+
+// StreamEncoder writes packets to any byte stream, frame after frame,
+// or split in HID reports, as on the usb link, when HIDReports is set, read back by a StreamDecoder with HIDReports set.
+type StreamEncoder struct {
+	HIDReports bool
+	// Checksum ending the frames, FrameChecksum by default
+	Checksum Checksum
+
+	writer io.Writer
+	report []byte
+}
+
+// NewStreamEncoder returns a StreamEncoder writing plain frames to writer
+func NewStreamEncoder(writer io.Writer) *StreamEncoder {
+	return &StreamEncoder{Checksum: FrameChecksum, writer: writer, report: make([]byte, MaxHIDFrameSize)}
+}
+
+// Encode writes the frame of packet
+func (encoder *StreamEncoder) Encode(packet *Packet) error {
+	frame, err := packet.encode(packet.Cmd|versionMask, encoder.Checksum)
+	if err != nil {
+		return err
+	}
+
+	if encoder.HIDReports {
+		_, err = writeHIDReports(encoder.writer, encoder.report, frame)
+	} else {
+		_, err = encoder.writer.Write(frame)
+	}
+	return err
+}
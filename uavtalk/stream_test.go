@@ -0,0 +1,120 @@
+package uavtalk
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStreamChecksum(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+
+	for _, checksum := range []Checksum{CRC8, CRC16} {
+		stream := new(bytes.Buffer)
+		encoder := NewStreamEncoder(stream)
+		encoder.Checksum = checksum
+		for instanceID := uint16(0); instanceID < 3; instanceID++ {
+			if err := encoder.Encode(NewPacket(definition, ObjectCmd, instanceID, waypointData())); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if expected := 3 * (frameLength(definition, ObjectCmd) + checksum.Size()); stream.Len() != expected {
+			t.Errorf("checksum size %d: %d bytes written, expected %d", checksum.Size(), stream.Len(), expected)
+		}
+
+		decoder := NewStreamDecoder(stream)
+		decoder.Checksum = checksum
+		for instanceID := uint16(0); instanceID < 3; instanceID++ {
+			packet, err := decoder.Next()
+			if err != nil {
+				t.Fatalf("checksum size %d, instance %d: %s", checksum.Size(), instanceID, err)
+			}
+			if packet.InstanceID != instanceID || packet.Data["Action"] != "Loiter" {
+				t.Errorf("checksum size %d: decoded instance %d %v", checksum.Size(), packet.InstanceID, packet.Data)
+			}
+		}
+		if _, err := decoder.Next(); err != io.EOF {
+			t.Errorf("checksum size %d: got %v at the end of the stream", checksum.Size(), err)
+		}
+	}
+}
+
+func TestStreamDecoderNoRerequests(t *testing.T) {
+	loadTestDefinitions(t)
+	defer ClearRerequestOnError("Waypoint")
+	if err := RerequestOnError("Waypoint", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupted := corruptFrame(encodeTestPacket(t, "Waypoint", ObjectCmd, 3, waypointData()))
+	valid := encodeTestPacket(t, "Waypoint", ObjectCmd, 4, waypointData())
+
+	decoder := NewStreamDecoder(bytes.NewReader(append(append([]byte(nil), corrupted...), valid...)))
+	packet, err := decoder.Next()
+	if err != nil || packet.InstanceID != 4 {
+		t.Fatalf("got %v %v, expected instance 4", packet, err)
+	}
+	if due := dueRerequests(); len(due) != 0 {
+		t.Errorf("%d requests queued by the stream decoder", len(due))
+	}
+
+	// the accumulator of the link still requests them
+	live := newAccumulator()
+	live.write(corrupted)
+	live.readPackets(func(*Packet) {})
+	if due := dueRerequests(); len(due) != 1 || due[0].InstanceID != 3 {
+		t.Errorf("%d requests queued by the link accumulator", len(due))
+	}
+}
+
+// oneByteReader returns the bytes of a stream one at a time
+type oneByteReader struct {
+	reader io.Reader
+}
+
+func (r oneByteReader) Read(b []byte) (int, error) {
+	return r.reader.Read(b[:1])
+}
+
+func TestStreamHIDReports(t *testing.T) {
+	loadTestDefinitions(t)
+
+	// chunked in several reports, and fitting in one
+	packets := []*Packet{
+		NewPacket(AllDefinitions.MustGetDefinitionForName("Waypoint"), ObjectCmd, 1, waypointData()),
+		NewPacket(AllDefinitions.MustGetDefinitionForName("Label"), ObjectCmd, 0, labelData()),
+		NewPacket(AllDefinitions.MustGetDefinitionForName("Waypoint"), ObjectRequest, 2, nil),
+	}
+
+	for _, oneByte := range []bool{false, true} {
+		stream := new(bytes.Buffer)
+		encoder := NewStreamEncoder(stream)
+		encoder.HIDReports = true
+		for _, packet := range packets {
+			if err := encoder.Encode(packet); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var reader io.Reader = stream
+		if oneByte {
+			reader = oneByteReader{stream}
+		}
+		decoder := NewStreamDecoder(reader)
+		decoder.HIDReports = true
+		for i, packet := range packets {
+			decoded, err := decoder.Next()
+			if err != nil {
+				t.Fatalf("packet %d: %s", i, err)
+			}
+			if decoded.Definition != packet.Definition || decoded.Cmd != packet.Cmd || decoded.InstanceID != packet.InstanceID {
+				t.Errorf("packet %d: decoded %s cmd %d instance %d", i, decoded.Definition.Name, decoded.Cmd, decoded.InstanceID)
+			}
+		}
+		if _, err := decoder.Next(); err != io.EOF {
+			t.Errorf("got %v at the end of the stream", err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package uavtalk
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log"
 
 	"code.google.com/p/go-charset/charset"
@@ -40,12 +41,46 @@ func toISO88591(utf8 string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	fmt.Fprintf(w, utf8)
+	io.WriteString(w, utf8)
 	w.Close()
 	return buf.String(), nil
 }
 
-func calculateID(uavdef *Definition) {
+// ObjectIDHash computes the object id of a definition, the low bit is then cleared by calculateID
+type ObjectIDHash func(definition *Definition) uint32
+
+// HashVersion is the firmware family whose object id hash is used, see ObjectIDHashVersion
+type HashVersion int
+
+const (
+	// OpenPilotHash is the hash of the OpenPilot GCS, kept by Taulabs
+	OpenPilotHash HashVersion = iota
+	// CustomHash is the hash of ObjectIDHasher
+	CustomHash
+)
+
+// ObjectIDHashVersion selects how the ids of the definitions loaded or registered without one are computed,
+// it has to be set before the definitions are loaded.
+var ObjectIDHashVersion = OpenPilotHash
+
+// ObjectIDHasher computes the ids when ObjectIDHashVersion is CustomHash, for firmwares computing their ids differently.
+var ObjectIDHasher ObjectIDHash = GCSObjectIDHash
+
+// hashes are the implementations of the versions, but CustomHash
+var hashes = map[HashVersion]ObjectIDHash{
+	OpenPilotHash: GCSObjectIDHash,
+}
+
+// Hash returns the implementation of the version, nil for an unknown version
+func (version HashVersion) Hash() ObjectIDHash {
+	if version == CustomHash {
+		return ObjectIDHasher
+	}
+	return hashes[version]
+}
+
+// GCSObjectIDHash hashes the definition the same way the OpenPilot GCS (and its Taulabs fork) does
+func GCSObjectIDHash(uavdef *Definition) uint32 {
 	hash := new(Hash)
 
 	hash.updateHashWithString(uavdef.Name)
@@ -55,7 +90,12 @@ func calculateID(uavdef *Definition) {
 	for _, field := range uavdef.Fields {
 		hash.updateHashWithString(field.Name)
 		hash.updateHashWithInt(uint32(field.Elements))
-		hash.updateHashWithInt(uint32(field.FieldTypeInfo.Index))
+		// the board knows string fields as uint8 arrays
+		typeIndex := field.FieldTypeInfo.Index
+		if field.FieldTypeInfo.Name == "string" {
+			typeIndex = 3
+		}
+		hash.updateHashWithInt(uint32(typeIndex))
 
 		if field.Type == "enum" {
 			for _, option := range field.Options {
@@ -63,6 +103,25 @@ func calculateID(uavdef *Definition) {
 			}
 		}
 	}
+	return uint32(*hash)
+}
+
+// calculateID sets the object id computed by hash, data object ids are even,
+// their meta object id being the next odd number.
+func calculateID(uavdef *Definition, hash ObjectIDHash) error {
+	objectID := hash(uavdef) & 0xFFFFFFFE
+	if objectID == 0 {
+		return fmt.Errorf("%s: computed object id is 0", uavdef.Name)
+	}
+	uavdef.ObjectID = objectID
+	return nil
+}
 
-	uavdef.ObjectID = uint32(*hash) & 0xFFFFFFFE
+// objectIDHash returns the hash of ObjectIDHashVersion
+func objectIDHash() (ObjectIDHash, error) {
+	hash := ObjectIDHashVersion.Hash()
+	if hash == nil {
+		return nil, fmt.Errorf("Unknown object id hash version %d", ObjectIDHashVersion)
+	}
+	return hash, nil
 }
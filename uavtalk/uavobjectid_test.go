@@ -0,0 +1,35 @@
+package uavtalk
+
+import "testing"
+
+func TestObjectIDHashVersions(t *testing.T) {
+	defer func() { ObjectIDHashVersion, ObjectIDHasher = OpenPilotHash, GCSObjectIDHash }()
+
+	// as published by the OpenPilot GCS for the definitions of testdata
+	published := map[string]uint32{
+		"ObjectPersistence":    0x99C63292,
+		"FlightTelemetryStats": 0x2F7E2902,
+		"GCSTelemetryStats":    0xABC72744,
+	}
+
+	ObjectIDHashVersion = OpenPilotHash
+	defs := testDefinitions(t)
+	for name, objectID := range published {
+		definition := defs.MustGetDefinitionForName(name)
+		if definition.ObjectID != objectID || definition.Meta.ObjectID != objectID|1 {
+			t.Errorf("%s id %#x, meta %#x, expected %#x", name, definition.ObjectID, definition.Meta.ObjectID, objectID)
+		}
+	}
+
+	ObjectIDHashVersion = CustomHash
+	ObjectIDHasher = func(definition *Definition) uint32 { return GCSObjectIDHash(definition) ^ 0x10 }
+	defs = testDefinitions(t)
+	if objectID := defs.MustGetDefinitionForName("ObjectPersistence").ObjectID; objectID != 0x99C63282 {
+		t.Errorf("custom hash: ObjectPersistence id %#x", objectID)
+	}
+
+	ObjectIDHashVersion = HashVersion(42)
+	if _, err := newDefinitions("testdata"); err == nil {
+		t.Error("loaded with an unknown hash version")
+	}
+}
@@ -7,12 +7,18 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
 
 var AllDefinitions Definitions
+
 var maxUAVObjectLength int
 
 // TODO: refactor for better value reading (encoding/binary ?)
@@ -20,7 +26,11 @@ var maxUAVObjectLength int
 // see parsing in rotonde HID
 
 const versionMask = 0x20
-const shortHeaderLength = 8
+
+// timestampedMask is set in the type byte of frames carrying a timestamp after the header
+const timestampedMask = 0x80
+
+const timestampLength = 2
 
 const MaxHIDFrameSize = 64
 
@@ -32,36 +42,83 @@ const ObjectNack = 4
 
 // Packet data from/to the flight controller
 type Packet struct {
+	// Definition is nil for the frames of unknown objects passed through, see PassUnknownObjects
 	Definition *Definition
+	ObjectID   uint32
 	Cmd        uint8
 	Length     uint16
 	InstanceID uint16
 	Data       map[string]interface{}
+
+	// RawData is a copy of the decoded body, only set when KeepRawData is true
+	RawData []byte
+
+	// Timestamp is the board time in milliseconds carried by timestamped frames, zero for the others
+	Timestamp uint16
 }
 
-func (packet *Packet) toBinary() ([]byte, error) {
-	writer := new(bytes.Buffer)
+// KeepRawData makes received packets carry a copy of their body in RawData, for debugging
+var KeepRawData = false
+
+// LenientDecoding makes bodies longer than the definition decode the known fields and ignore
+// the trailing bytes, as sent by a firmware with a newer version of the object.
+// When false such packets are rejected.
+var LenientDecoding = true
+
+// PassUnknownObjects makes the frames of objects missing from AllDefinitions reach outChan instead of being dropped,
+// so they can be forwarded to a peer knowing them. Such packets have a nil Definition, their ObjectID and Cmd set,
+// and RawData holding everything between the object id and the crc: without the definition there is no telling
+// whether it starts with an instance id.
+var PassUnknownObjects = false
+
+// checkInstanceID rejects instance ids other than 0 for single instance objects, which would be silently dropped
+func checkInstanceID(definition *Definition, instanceID uint16) error {
+	if definition.SingleInstance && instanceID != 0 {
+		return fmt.Errorf("%s is a single instance object, got instance id %d", definition.Name, instanceID)
+	}
+	return nil
+}
+
+// writeHeader writes the frame header, typeByte being the cmd with the protocol version bits
+func (packet *Packet) writeHeader(writer *bytes.Buffer, typeByte uint8) error {
+	if packet.Definition == nil {
+		return newCodecError(ErrUnknownObject, "object %d: can't encode a packet without definition", packet.ObjectID)
+	}
+	if err := checkInstanceID(packet.Definition, packet.InstanceID); err != nil {
+		return err
+	}
 
 	if err := binary.Write(writer, binary.LittleEndian, uint8(0x3c)); err != nil {
-		return nil, err
+		return err
 	}
 
-	if err := binary.Write(writer, binary.LittleEndian, packet.Cmd|versionMask); err != nil {
-		return nil, err
+	if err := binary.Write(writer, binary.LittleEndian, typeByte); err != nil {
+		return err
 	}
 
 	if err := binary.Write(writer, binary.LittleEndian, packet.Length); err != nil {
-		return nil, err
+		return err
 	}
 
-	if err := binary.Write(writer, binary.LittleEndian, packet.Definition.ObjectID); err != nil {
-		return nil, err
-	}
+	return Layout.writeIDs(writer, packet.Definition.ObjectID, packet.Definition.SingleInstance, packet.InstanceID)
+}
 
-	if packet.Definition.SingleInstance == false {
-		if err := binary.Write(writer, binary.LittleEndian, packet.InstanceID); err != nil {
-			return nil, err
-		}
+func (packet *Packet) toBinary() ([]byte, error) {
+	return packet.encode(packet.Cmd|versionMask, FrameChecksum)
+}
+
+// EncodeRaw encodes the packet with typeByte written verbatim in place of the cmd and version,
+// meant for testing peers against non standard frames. The body is still chosen from packet.Cmd.
+func (packet *Packet) EncodeRaw(typeByte uint8) ([]byte, error) {
+	return packet.encode(typeByte, FrameChecksum)
+}
+
+// encode encodes the packet with typeByte and checksum
+func (packet *Packet) encode(typeByte uint8, checksum Checksum) ([]byte, error) {
+	writer := new(bytes.Buffer)
+
+	if err := packet.writeHeader(writer, typeByte); err != nil {
+		return nil, err
 	}
 
 	if packet.Cmd == ObjectCmd || packet.Cmd == ObjectCmdWithAck {
@@ -75,8 +132,7 @@ func (packet *Packet) toBinary() ([]byte, error) {
 		}
 	}
 
-	cks := computeCrc8(0, writer.Bytes())
-	if err := binary.Write(writer, binary.LittleEndian, cks); err != nil {
+	if _, err := writer.Write(checksum.Sum(writer.Bytes())); err != nil {
 		return nil, err
 	}
 
@@ -99,11 +155,31 @@ func byteArrayToInt16(b []byte) uint16 {
 	return (uint16(b[1]) << 8) | (uint16(b[0]))
 }
 
-func packetComplete(buffer []byte) (bool, int, int, error) {
-	start := 0
+// MaxObjectBodySize is the largest body accepted in a received frame, a frame claiming a longer one
+// is not collected, its sync byte being taken as garbage. 0 stands for the largest loaded definition.
+var MaxObjectBodySize = 0
+
+// maxLengthField returns the largest value accepted in the length field of a received frame
+// with the given type byte, its header being at most that of a multi instance object
+func maxLengthField(typeByte byte) int {
+	length := Layout.length(false)
+	if typeByte&timestampedMask != 0 {
+		length += timestampLength
+	}
+	if MaxObjectBodySize > 0 {
+		return length + MaxObjectBodySize
+	}
+	return length + maxUAVObjectLength
+}
+
+// packetComplete looks for a complete packet ending with checksum in buffer starting at start,
+// returns the packet bounds, or when no packet is complete, the offset from which the scan should resume.
+// The checksum is only verified when verifyChecksum is true.
+func packetComplete(buffer []byte, start int, checksum Checksum, verifyChecksum bool) (bool, int, int, error) {
 	for {
 		offset := -1
-		for i := start; i < len(buffer)-shortHeaderLength+1; i++ {
+		headerLength := Layout.length(true)
+		for i := start; i < len(buffer)-headerLength+1; i++ {
 			if buffer[i] == 0x3c {
 				offset = i
 				break
@@ -111,103 +187,224 @@ func packetComplete(buffer []byte) (bool, int, int, error) {
 		}
 
 		if offset < 0 {
-			return false, 0, 0, nil
+			// only the last bytes, too short for a header, can still be the start of a packet
+			if resume := len(buffer) - headerLength + 1; resume > start {
+				return false, resume, 0, nil
+			}
+			return false, start, 0, nil
 		}
 
 		length := byteArrayToInt16(buffer[offset+2 : offset+4])
 
-		if int(length) > maxUAVObjectLength+shortHeaderLength+2 {
+		// a length that can't hold a header or longer than allowed means this is not a sync byte
+		if int(length) < headerLength || int(length) > maxLengthField(buffer[offset+1]) {
 			start = offset + 1
 			continue
 		}
 
-		if int(length)+1 > len(buffer)-offset {
-			return false, 0, 0, nil
+		end := offset + int(length) + checksum.Size()
+		if end > len(buffer) {
+			return false, offset, 0, nil
 		}
 
-		cks := buffer[offset+int(length)]
+		if verifyChecksum == false {
+			return true, offset, end, nil
+		}
+
+		cks := buffer[offset+int(length) : end]
 
-		if cks != computeCrc8(0, buffer[offset:offset+int(length)]) {
-			return false, offset, offset + int(length) + 1, fmt.Errorf("Wrong crc8")
+		if bytes.Equal(cks, checksum.Sum(buffer[offset:offset+int(length)])) == false {
+			return false, offset, end, ErrBadCRC
 		}
 
-		return true, offset, offset + int(length) + 1, nil
+		return true, offset, end, nil
 	}
 }
 
-func newPacketFromBinary(binaryPacket []byte) (*Packet, error) {
-	headerSize := shortHeaderLength
+// newPacketFromBinary decodes a complete frame ending with a checksum of checksumSize bytes
+func newPacketFromBinary(binaryPacket []byte, checksumSize int) (*Packet, error) {
 	buffer := Packet{}
 
-	buffer.Cmd = binaryPacket[1] ^ versionMask
+	buffer.Cmd = (binaryPacket[1] &^ timestampedMask) ^ versionMask
 	buffer.Length = byteArrayToInt16(binaryPacket[2:4])
-	objectID := byteArrayToInt32(binaryPacket[4:8])
+	buffer.ObjectID = Layout.readObjectID(binaryPacket)
 
 	var err error
-	buffer.Definition, err = AllDefinitions.GetDefinitionForObjectID(objectID)
+	buffer.Definition, err = Layout.definitionForObjectID(AllDefinitions, buffer.ObjectID)
 	if err != nil {
+		if PassUnknownObjects {
+			buffer.RawData = append([]byte(nil), binaryPacket[4+Layout.ObjectIDSize:len(binaryPacket)-checksumSize]...)
+			buffer.Data = map[string]interface{}{}
+			return &buffer, nil
+		}
 		return nil, err
 	}
+	headerSize := Layout.length(buffer.Definition.SingleInstance)
+	if len(binaryPacket) < headerSize+checksumSize {
+		return nil, newCodecError(ErrShortBuffer, "%s: frame too short for its header", buffer.Definition.Name)
+	}
 	if buffer.Definition.SingleInstance == false {
-		buffer.InstanceID = byteArrayToInt16(binaryPacket[8:10])
-		headerSize += 2
+		buffer.InstanceID = Layout.readInstanceID(binaryPacket)
+	}
+	if binaryPacket[1]&timestampedMask != 0 {
+		if len(binaryPacket) < headerSize+timestampLength+checksumSize {
+			return nil, newCodecError(ErrShortBuffer, "%s: frame too short for its timestamp", buffer.Definition.Name)
+		}
+		buffer.Timestamp = byteArrayToInt16(binaryPacket[headerSize : headerSize+timestampLength])
+		headerSize += timestampLength
 	}
 
-	binaryData := binaryPacket[headerSize : len(binaryPacket)-1]
+	binaryData := binaryPacket[headerSize : len(binaryPacket)-checksumSize]
+	if KeepRawData {
+		buffer.RawData = append([]byte(nil), binaryData...)
+	}
 
 	if buffer.Cmd == ObjectCmd || buffer.Cmd == ObjectCmdWithAck {
+		byteLength := buffer.Definition.Fields.ByteLength()
+		if len(binaryData) < byteLength {
+			return nil, newCodecError(ErrLengthMismatch, "%s: body too short, %d bytes instead of %d", buffer.Definition.Name, len(binaryData), byteLength)
+		} else if len(binaryData) > byteLength {
+			if LenientDecoding == false {
+				return nil, newCodecError(ErrLengthMismatch, "%s: body too long, %d bytes instead of %d", buffer.Definition.Name, len(binaryData), byteLength)
+			}
+			log.Debugf("%s: ignoring %d trailing bytes, the board may have a newer version of the object", buffer.Definition.Name, len(binaryData)-byteLength)
+			binaryData = binaryData[:byteLength]
+		}
 		buffer.Data, err = uAVTalkToMap(buffer.Definition, binaryData)
 		if err != nil {
 			return nil, err
 		}
 	} else {
 		buffer.Data = map[string]interface{}{}
+		// some boards send the reason of a nack as a status byte
+		if buffer.Cmd == ObjectNack && len(binaryData) > 0 {
+			buffer.Data["Status"] = binaryData[0]
+		}
 	}
 
 	return &buffer, nil
 }
 
-func NewPacket(definition *Definition, cmd uint8, instanceID uint16, data map[string]interface{}) *Packet {
-	buffer := Packet{}
-	buffer.Definition = definition
-	buffer.Cmd = cmd
-	buffer.InstanceID = instanceID
-
+// frameLength returns the value of the length field of a frame: header and body, without the crc
+func frameLength(definition *Definition, cmd uint8) int {
 	var fieldsLength int
 	if cmd == ObjectCmd || cmd == ObjectCmdWithAck {
 		fieldsLength = definition.Fields.ByteLength()
 	}
+	return Layout.length(definition.SingleInstance) + fieldsLength
+}
 
-	if buffer.Definition.SingleInstance == false {
-		buffer.Length = uint16(shortHeaderLength + fieldsLength + 2)
-	} else {
-		buffer.Length = uint16(shortHeaderLength + fieldsLength)
-	}
+// FrameSize returns the size in bytes of a whole frame (header, body and crc) of the object for cmd,
+// with the current Layout and FrameChecksum.
+func FrameSize(definition *Definition, cmd uint8) int {
+	return frameLength(definition, cmd) + FrameChecksum.Size()
+}
+
+func NewPacket(definition *Definition, cmd uint8, instanceID uint16, data map[string]interface{}) *Packet {
+	buffer := Packet{}
+	buffer.Definition = definition
+	buffer.ObjectID = definition.ObjectID
+	buffer.Cmd = cmd
+	buffer.InstanceID = instanceID
+	buffer.Length = uint16(frameLength(definition, cmd))
 	buffer.Data = data
 	return &buffer
 }
 
-func LoadDefinitions(definitionsDir string) {
-	defs, err := newDefinitions(definitionsDir)
+// LoadDefinitions loads the xml definitions found in the directories into AllDefinitions,
+// later directories override the definitions of the previous ones by name.
+func LoadDefinitions(definitionsDirs ...string) {
+	defs, err := newDefinitions(definitionsDirs...)
 	if err != nil {
 		log.Fatal(err)
 	}
-	AllDefinitions = defs
+	for _, problem := range defs.Validate() {
+		log.Warning(problem)
+	}
+	setDefinitions(defs)
 }
 
-// Start starts the UAVTalk connection to dispatcher
-func Start(inChan chan Packet, outChan chan Packet) {
-	for _, definition := range AllDefinitions {
+// ReloadDefinitions replaces AllDefinitions as LoadDefinitions does, but returns the errors
+// instead of exiting, AllDefinitions being kept as it was.
+// Definitions are never modified once loaded, so packets already queued are still encoded with the definition
+// they were created with. The sampling and re-request policies are moved to the new definition of their object,
+// they are dropped when the object is gone or its id changed.
+func ReloadDefinitions(definitionsDirs ...string) error {
+	defs, err := newDefinitions(definitionsDirs...)
+	if err != nil {
+		return err
+	}
+	for _, problem := range defs.Validate() {
+		log.Warning(problem)
+	}
+	setDefinitions(defs)
+	rebindSamplingPolicies(defs)
+	rebindRerequestPolicies(defs)
+	return nil
+}
+
+// setDefinitions replaces AllDefinitions, the slice is never modified in place
+// so a lookup in progress keeps a consistent set.
+func setDefinitions(defs Definitions) {
+	length := 0
+	for _, definition := range defs {
 		tmp := definition.Fields.ByteLength()
-		tmp += shortHeaderLength
-		if definition.SingleInstance == false {
-			tmp += 2
+		tmp += Layout.length(definition.SingleInstance)
+		if tmp > length {
+			length = tmp
+		}
+	}
+	AllDefinitions = defs
+	maxUAVObjectLength = length
+}
+
+var registerLock sync.Mutex
+
+// RegisterDefinition adds a definition learned at runtime (announced by a peer rather than loaded from a file)
+// to AllDefinitions, with its meta definition, so frames for it can be decoded.
+// The fields are set up and the object id computed when it is 0.
+func RegisterDefinition(definition *Definition) error {
+	registerLock.Lock()
+	defer registerLock.Unlock()
+
+	if err := definition.FinishSetup(); err != nil {
+		return err
+	}
+	if definition.ObjectID == 0 {
+		hash, err := objectIDHash()
+		if err != nil {
+			return err
+		}
+		if err := calculateID(definition, hash); err != nil {
+			return err
+		}
+	}
+
+	for _, other := range AllDefinitions {
+		if other.ObjectID == definition.ObjectID || other.ObjectID == MetaObjectID(definition.ObjectID) {
+			return fmt.Errorf("%s: object id %d already used by %s", definition.Name, definition.ObjectID, other.Name)
 		}
-		if tmp > maxUAVObjectLength {
-			maxUAVObjectLength = tmp
+		if strings.ToLower(other.Name) == strings.ToLower(definition.Name) {
+			return fmt.Errorf("%s: a definition with the same name is already loaded", definition.Name)
 		}
 	}
 
+	if _, err := NewMetaDefinition(definition); err != nil {
+		return err
+	}
+
+	defs := make(Definitions, 0, len(AllDefinitions)+2)
+	defs = append(defs, AllDefinitions...)
+	defs = append(defs, definition, definition.Meta)
+	setDefinitions(defs)
+	return nil
+}
+
+// Start starts the UAVTalk connection to dispatcher.
+// inChan can be written from any number of goroutines, a single goroutine writes the packets on the link.
+func Start(inChan chan Packet, outChan chan Packet) {
+	setBacklogs(inChan, outChan)
+
 	log.Infof("%d xml files loaded, maxUAVObjectLength: %d", len(AllDefinitions), maxUAVObjectLength)
 
 	for {
@@ -215,11 +412,40 @@ func Start(inChan chan Packet, outChan chan Packet) {
 	}
 }
 
+// LinkStateHandler, when set, is called with true each time the link is opened, and false when it is lost
+var LinkStateHandler func(up bool)
+
+var linkUp int32
+
+// LinkUp tells whether the link to the flight controller is currently open
+func LinkUp() bool {
+	return atomic.LoadInt32(&linkUp) == 1
+}
+
+func setLinkState(up bool) {
+	if up {
+		atomic.StoreInt32(&linkUp, 1)
+	} else {
+		atomic.StoreInt32(&linkUp, 0)
+	}
+	if LinkStateHandler != nil {
+		LinkStateHandler(up)
+	}
+}
+
+// ReadTimeout is how long the link can stay silent before being considered lost and reopened, 0 disables it.
+// The time spent waiting for the consumer of outChan (see BlockOnFullBacklog) doesn't count as silence.
+var ReadTimeout = 10 * time.Second
+
+// OpenLink opens the link to the flight controller, NewUSBLink unless replaced before Start
+var OpenLink = NewUSBLink
+
+// start opens a link and runs it until it is lost
 func start(inChan chan Packet, outChan chan Packet) {
 	var link Linker
 	var err error
 	for {
-		link, err = NewUSBLink() // newUSBLink() ou newTCPLink()
+		link, err = OpenLink() // NewUSBLink ou NewTCPLink
 		if err != nil {
 			log.Warning(err)
 			time.Sleep(1 * time.Second)
@@ -228,114 +454,400 @@ func start(inChan chan Packet, outChan chan Packet) {
 		break
 	}
 
-	defer link.Close()
+	// set per link, read by both goroutines below and nothing else
+	checksum := CRC8
+	if checksumLink, ok := link.(ChecksumLinker); ok {
+		checksum = checksumLink.Checksum()
+	}
+
+	setLinkState(true)
+
+	quit := make(chan struct{})
+	lost := make(chan error, 2)
+	clock := DefaultClock
+	// the goroutines using the link, it is only closed once they are done with it
+	var users sync.WaitGroup
+	defer func() {
+		close(quit)
+		users.Wait()
+		link.Close()
+		setLinkState(false)
+	}()
+
+	// since when the reader has been waiting for data, 0 while it hands the packets read over
+	silentSince := clock.Now().UnixNano()
+
 	// From Controller
+	users.Add(1)
 	go func() {
+		defer users.Done()
 		packet := make([]byte, MaxHIDFrameSize)
-		buffer := make([]byte, 0, 4096)
+		accumulator := newAccumulator()
+		accumulator.clock = clock
+		accumulator.checksum = checksum
+		if trustedLink, ok := link.(TrustedLinker); ok {
+			accumulator.skipChecksum = trustedLink.SkipChecksum()
+		}
 		for {
+			select {
+			case <-quit:
+				return
+			default:
+			}
 			n, err := link.Read(packet)
 			if err != nil {
-				log.Fatal(err)
+				lost <- linkLostError(err)
 				return
 			}
 			if n == 0 {
 				continue
 			}
-
-			buffer = append(buffer, packet[0:n]...)
-
-			for {
-				ok, from, to, err := packetComplete(buffer)
-				if err == nil {
-					if ok != true {
-						break
-					}
-
-					if uavTalkObject, err := newPacketFromBinary(buffer[from:to]); err == nil {
-						outChan <- *uavTalkObject
-					} else {
-						log.Warning(err)
-						PrintHex(buffer[from:to], to-from)
-					}
-				} else {
-					// the packet is complete but its integrity is seriously questionned,
-					// we go through so we can strip it from buffer
-					log.Warning(err)
-					PrintHex(buffer[from:to], to-from)
+			atomic.StoreInt64(&silentSince, 0)
+			atomic.AddUint64(&bytesIn, uint64(n))
+
+			quitting := false
+			accumulator.write(packet[0:n])
+			err = accumulator.readPackets(func(uavTalkObject *Packet) {
+				if quitting {
+					return
+				}
+				if uavTalkObject.Cmd == ObjectAck || uavTalkObject.Cmd == ObjectNack {
+					replyBatchAck(*uavTalkObject)
 				}
-				copy(buffer, buffer[to:])
-				buffer = buffer[0 : len(buffer)-to]
+				if uavTalkObject.Definition == nil {
+					// unknown object, see PassUnknownObjects
+					quitting = pushOut(outChan, *uavTalkObject, quit) == false
+					return
+				}
+				LastValues.Update(uavTalkObject)
+				if downsampled(uavTalkObject, clock.Now()) {
+					return
+				}
+				quitting = pushOut(outChan, *uavTalkObject, quit) == false
+			})
+			if quitting {
+				// the packets left belong to a link given up on
+				return
+			}
+			if err != nil {
+				// out of sync, the link is reopened from scratch
+				lost <- err
+				return
 			}
+			atomic.StoreInt64(&silentSince, clock.Now().UnixNano())
 		}
 	}()
 
-	// To Controller
+	// To Controller, the only goroutine writing on the link: everything sent (inChan, polls, SendBatch, SendRaw)
+	// goes through it, so frames are never interleaved.
+	users.Add(1)
 	go func() {
+		defer users.Done()
 		for {
+			if waitSendingResumed(quit) == false {
+				return
+			}
+
 			var binaryPacket []byte
+			var err error
 			select {
+			case <-quit:
+				return
+			case <-pausedChan:
+				continue
 			case packet := <-inChan:
-				binaryPacket, err = packet.toBinary()
+				binaryPacket, err = encodeForLink(&packet, checksum)
 				if err != nil {
 					log.Warning(err)
 					continue
 				}
+			case batch := <-batchChan:
+				if err := batch.encode(checksum); err != nil {
+					batch.result <- err
+					continue
+				}
+				// written in one go, nothing from inChan can get in between
+				err = batch.writeTo(link)
+				if err != nil {
+					err = linkLostError(err)
+				}
+				batch.result <- err
+				if err != nil {
+					lost <- err
+					return
+				}
+				for _, frame := range batch.frames {
+					atomic.AddUint64(&bytesOut, uint64(len(frame)))
+				}
+				continue
 			}
 
 			_, err = link.Write(binaryPacket)
 			if err != nil {
-				log.Fatal(err)
+				lost <- linkLostError(err)
+				return
+			}
+			atomic.AddUint64(&bytesOut, uint64(len(binaryPacket)))
+		}
+	}()
+
+	// Polled objects, and the ones to request again after an error
+	go func() {
+		for {
+			timer := clock.NewTimer(pollResolution)
+			select {
+			case <-quit:
+				timer.Stop()
 				return
+			case <-timer.C():
+			}
+			for _, definition := range duePolls(clock.Now()) {
+				select {
+				case <-quit:
+					return
+				case inChan <- *NewPacket(definition, ObjectRequest, 0, map[string]interface{}{}):
+				}
+			}
+			for _, request := range dueRerequests() {
+				select {
+				case <-quit:
+					return
+				case inChan <- request:
+				}
 			}
 		}
 	}()
-	select {}
+
+	// the link reader can block, the timeout is checked from here
+	for {
+		watchdog := clock.NewTimer(1 * time.Second)
+		select {
+		case err := <-lost:
+			watchdog.Stop()
+			log.Warning(err)
+			return
+		case <-watchdog.C():
+			sampleLinkRates(clock.Now())
+			since := atomic.LoadInt64(&silentSince)
+			silence := time.Duration(clock.Now().UnixNano() - since)
+			if ReadTimeout > 0 && since != 0 && silence > ReadTimeout {
+				log.Warningf("Link lost: nothing received for %s", silence)
+				return
+			}
+		}
+	}
 }
 
-// newDefinitions loads all xml files from a directory
-func newDefinitions(dir string) (Definitions, error) {
-	fileInfos, err := ioutil.ReadDir(dir)
+// newDefinitions loads all xml files from directories, a definition overrides
+// the one with the same name loaded from a previous directory.
+func newDefinitions(dirs ...string) (Definitions, error) {
+	// read once, for all the definitions to be hashed the same way
+	hash, err := objectIDHash()
 	if err != nil {
 		return nil, err
 	}
 
-	AllDefinitions := make([]*Definition, 0, 150)
-	for _, fileInfo := range fileInfos {
-		filePath := fmt.Sprintf("%s%s", dir, fileInfo.Name())
-		definition, err := newDefinition(filePath)
+	var filePaths []string
+	for _, dir := range dirs {
+		fileInfos, err := readDefinitionsDir(dir)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		_, err = NewMetaDefinition(definition)
-		if err != nil {
-			log.Fatal(err)
+		for _, fileInfo := range fileInfos {
+			filePaths = append(filePaths, filepath.Join(dir, fileInfo.Name()))
+		}
+	}
+
+	parsed, err := parseDefinitionFiles(filePaths, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := make([]*Definition, 0, len(parsed))
+	indexes := make(map[string]int)
+	for i, definition := range parsed {
+		name := strings.ToLower(definition.Name)
+		if index, ok := indexes[name]; ok {
+			log.Infof("%s from %s overrides the previously loaded one", definition.Name, filePaths[i])
+			definitions[index] = definition
+			continue
+		}
+		indexes[name] = len(definitions)
+		definitions = append(definitions, definition)
+	}
+
+	if len(definitions) == 0 {
+		return nil, fmt.Errorf("No object loaded from %s", strings.Join(dirs, ", "))
+	}
+
+	AllDefinitions := make([]*Definition, 0, 2*len(definitions))
+	for _, definition := range definitions {
+		if _, err := NewMetaDefinition(definition); err != nil {
+			return nil, err
 		}
 		AllDefinitions = append(AllDefinitions, definition, definition.Meta)
 	}
+
+	objectIDs := make(map[uint32]*Definition, len(AllDefinitions))
+	for _, definition := range AllDefinitions {
+		if other, ok := objectIDs[definition.ObjectID]; ok {
+			return nil, fmt.Errorf("%s and %s share the same object id %d", other.Name, definition.Name, definition.ObjectID)
+		}
+		objectIDs[definition.ObjectID] = definition
+	}
 	return AllDefinitions, nil
 }
 
+// parseDefinitionFiles parses the files with up to GOMAXPROCS workers,
+// definitions are returned in the order of filePaths, and the error is the one of the first failing file.
+func parseDefinitionFiles(filePaths []string, hash ObjectIDHash) ([]*Definition, error) {
+	definitions := make([]*Definition, len(filePaths))
+	errs := make([]error, len(filePaths))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				definitions[i], errs[i] = cachedDefinition(filePaths[i], hash)
+			}
+		}()
+	}
+	for i := range filePaths {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return definitions, nil
+}
+
+// CacheDefinitionFiles keeps the parsed definition files, so loading them again
+// only parses the files whose modification time or size changed.
+// The xml is cached as parsed, each load sets up a copy of its own and computes its object id again,
+// so the cache holds whatever ObjectIDHashVersion.
+var CacheDefinitionFiles = true
+
+type definitionCacheEntry struct {
+	modTime    time.Time
+	size       int64
+	definition *Definition
+}
+
+var definitionCacheLock sync.Mutex
+var definitionCache = map[string]definitionCacheEntry{}
+
+// cachedDefinition returns a new definition for a file, parsed again only when it changed since it was cached
+func cachedDefinition(filePath string, hash ObjectIDHash) (*Definition, error) {
+	if CacheDefinitionFiles == false {
+		return newDefinition(filePath, hash)
+	}
+
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	definitionCacheLock.Lock()
+	entry, ok := definitionCache[filePath]
+	definitionCacheLock.Unlock()
+	if ok == false || entry.modTime.Equal(fileInfo.ModTime()) == false || entry.size != fileInfo.Size() {
+		parsed, err := parseDefinitionFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		entry = definitionCacheEntry{fileInfo.ModTime(), fileInfo.Size(), parsed}
+		definitionCacheLock.Lock()
+		definitionCache[filePath] = entry
+		definitionCacheLock.Unlock()
+	}
+
+	// the cached definition is never set up, nor returned
+	return setupDefinition(filePath, entry.definition.Clone(), hash)
+}
+
+// readDefinitionsDir returns the xml files of a definitions directory,
+// telling apart a missing directory, an empty one and one without xml files.
+func readDefinitionsDir(dir string) ([]os.FileInfo, error) {
+	dirInfo, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("Definitions directory %s does not exist", dir)
+	} else if err != nil {
+		return nil, err
+	} else if dirInfo.IsDir() == false {
+		return nil, fmt.Errorf("Definitions directory %s is not a directory", dir)
+	}
+
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(fileInfos) == 0 {
+		return nil, fmt.Errorf("Definitions directory %s is empty", dir)
+	}
+
+	xmlFileInfos := make([]os.FileInfo, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		if fileInfo.IsDir() || strings.ToLower(filepath.Ext(fileInfo.Name())) != ".xml" {
+			continue
+		}
+		xmlFileInfos = append(xmlFileInfos, fileInfo)
+	}
+	if len(xmlFileInfos) == 0 {
+		return nil, fmt.Errorf("No xml file in definitions directory %s", dir)
+	}
+	return xmlFileInfos, nil
+}
+
 // NewDefinition create a Definition from an xml file.
-func newDefinition(filePath string) (*Definition, error) {
+func newDefinition(filePath string, hash ObjectIDHash) (*Definition, error) {
+	definition, err := parseDefinitionFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return setupDefinition(filePath, definition, hash)
+}
+
+// parseDefinitionFile returns the definition of an xml file as parsed, before FinishSetup
+func parseDefinitionFile(filePath string) (*Definition, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
 	decoder := xml.NewDecoder(file)
 
 	var content = &struct {
 		Definition *Definition `xml:"object"`
 	}{}
-	decoder.Decode(content)
+	if err := decoder.Decode(content); err != nil {
+		return nil, fmt.Errorf("%s: not a valid xml definition: %s", filePath, err)
+	}
 
 	definition := content.Definition
+	if definition == nil {
+		return nil, fmt.Errorf("%s: no object definition found", filePath)
+	}
+	return definition, nil
+}
+
+// setupDefinition finishes the setup of a parsed definition and computes its object id with hash
+func setupDefinition(filePath string, definition *Definition, hash ObjectIDHash) (*Definition, error) {
 	if err := definition.FinishSetup(); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %s", filePath, err)
 	}
 
-	calculateID(definition)
+	if err := calculateID(definition, hash); err != nil {
+		return nil, err
+	}
 
 	return definition, nil
 }
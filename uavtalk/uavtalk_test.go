@@ -0,0 +1,704 @@
+package uavtalk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	loadTestDefinitions(t)
+
+	tests := []struct {
+		name       string
+		cmd        uint8
+		instanceID uint16
+		data       map[string]interface{}
+		expected   map[string]interface{}
+	}{
+		{"AttitudeActual", ObjectCmd, 0, attitudeData(), map[string]interface{}{
+			"q1": float32(1), "q2": float32(0), "q3": float32(0), "q4": float32(0),
+			"Roll": float32(12.5), "Pitch": float32(-3.25), "Yaw": float32(180),
+		}},
+		{"Waypoint", ObjectCmdWithAck, 3, waypointData(), map[string]interface{}{
+			"Position": map[string]interface{}{"North": float32(1.5), "East": float32(-2), "Down": float32(-10.25)},
+			"Velocity": float32(3),
+			"Distance": int32(-2147483648),
+			"Action":   "Loiter",
+			"Modes":    []interface{}{"On", "Off", "On", "Off"},
+			"Counter":  []interface{}{int16(-32768), int16(32767)},
+			"Sign":     int8(-1),
+		}},
+		{"Label", ObjectCmd, 0, labelData(), map[string]interface{}{"Text": "quad450", "Value": uint16(65535), "Enabled": "True"}},
+		{"Waypoint", ObjectRequest, 7, map[string]interface{}{}, map[string]interface{}{}},
+		{"AttitudeActual", ObjectAck, 0, map[string]interface{}{}, map[string]interface{}{}},
+	}
+
+	for _, test := range tests {
+		definition := AllDefinitions.MustGetDefinitionForName(test.name)
+		frame := encodeTestPacket(t, test.name, test.cmd, test.instanceID, test.data)
+		if len(frame) != FrameSize(definition, test.cmd) {
+			t.Errorf("%s cmd %d: %d bytes encoded, FrameSize is %d", test.name, test.cmd, len(frame), FrameSize(definition, test.cmd))
+		}
+
+		decoded, err := newPacketFromBinary(frame, FrameChecksum.Size())
+		if err != nil {
+			t.Errorf("%s cmd %d: %s", test.name, test.cmd, err)
+			continue
+		}
+		if decoded.Definition != definition || decoded.Cmd != test.cmd || decoded.InstanceID != test.instanceID {
+			t.Errorf("%s cmd %d instance %d: header decoded as %s cmd %d instance %d", test.name, test.cmd, test.instanceID, decoded.Definition.Name, decoded.Cmd, decoded.InstanceID)
+		}
+		if reflect.DeepEqual(decoded.Data, test.expected) == false {
+			t.Errorf("%s cmd %d: decoded %v, expected %v", test.name, test.cmd, decoded.Data, test.expected)
+		}
+	}
+}
+
+func TestKeepRawData(t *testing.T) {
+	loadTestDefinitions(t)
+	defer func() { KeepRawData = false }()
+
+	frame := encodeTestPacket(t, "Label", ObjectCmd, 0, labelData())
+	for _, keep := range []bool{false, true} {
+		KeepRawData = keep
+		decoded, err := newPacketFromBinary(frame, FrameChecksum.Size())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected []byte
+		if keep {
+			expected = frame[Layout.length(true) : len(frame)-1]
+		}
+		if bytes.Equal(decoded.RawData, expected) == false {
+			t.Errorf("KeepRawData %t: RawData %x", keep, decoded.RawData)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	loadTestDefinitions(t)
+	defer func() { LenientDecoding = true }()
+
+	frame := encodeTestPacket(t, "AttitudeActual", ObjectCmd, 0, attitudeData())
+	unknown := append([]byte(nil), frame...)
+	unknown[4] ^= 0x02
+	short := append(append([]byte(nil), frame[:len(frame)-5]...), 0)
+	long := append(append([]byte(nil), frame[:len(frame)-1]...), 1, 2, 3, 0)
+
+	tests := []struct {
+		frame   []byte
+		lenient bool
+		kind    error
+	}{
+		{unknown, true, ErrUnknownObject},
+		{short, true, ErrLengthMismatch},
+		{long, false, ErrLengthMismatch},
+		{long, true, nil},
+	}
+
+	for i, test := range tests {
+		LenientDecoding = test.lenient
+		_, err := newPacketFromBinary(test.frame, FrameChecksum.Size())
+		if ErrorKind(err) != test.kind {
+			t.Errorf("case %d: got error %v, expected kind %v", i, err, test.kind)
+		}
+	}
+}
+
+func TestNackStatus(t *testing.T) {
+	loadTestDefinitions(t)
+
+	frame := encodeTestPacket(t, "AttitudeActual", ObjectNack, 0, nil)
+	withStatus := sealFrame(append(append([]byte(nil), frame[:len(frame)-1]...), 7))
+
+	tests := []struct {
+		frame    []byte
+		expected map[string]interface{}
+	}{
+		{frame, map[string]interface{}{}},
+		{withStatus, map[string]interface{}{"Status": uint8(7)}},
+	}
+	for i, test := range tests {
+		decoded, err := newPacketFromBinary(test.frame, FrameChecksum.Size())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Cmd != ObjectNack || reflect.DeepEqual(decoded.Data, test.expected) == false {
+			t.Errorf("case %d: decoded cmd %d %v", i, decoded.Cmd, decoded.Data)
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	loadTestDefinitions(t)
+
+	tests := []struct {
+		name       string
+		instanceID uint16
+		data       map[string]interface{}
+	}{
+		// single instance objects only have instance 0
+		{"AttitudeActual", 1, attitudeData()},
+		{"Waypoint", 0, map[string]interface{}{}},
+		{"Label", 0, map[string]interface{}{"Text": "too long for 8 bytes", "Value": float64(0), "Enabled": "True"}},
+		{"Label", 0, map[string]interface{}{"Text": "", "Value": float64(0), "Enabled": "Maybe"}},
+	}
+
+	for _, test := range tests {
+		packet := NewPacket(AllDefinitions.MustGetDefinitionForName(test.name), ObjectCmd, test.instanceID, test.data)
+		if _, err := packet.toBinary(); err == nil {
+			t.Errorf("%s instance %d with %v: encoded without error", test.name, test.instanceID, test.data)
+		}
+	}
+}
+
+// frameWithLength returns the start of a frame whose length field is length, followed by enough bytes for a header
+func frameWithLength(length uint16) []byte {
+	frame := []byte{0x3c, ObjectCmd | versionMask, byte(length), byte(length >> 8)}
+	return append(frame, make([]byte, Layout.length(true)-len(frame))...)
+}
+
+func TestPacketCompleteLength(t *testing.T) {
+	loadTestDefinitions(t)
+	valid := encodeTestPacket(t, "AttitudeActual", ObjectCmd, 0, attitudeData())
+
+	for _, length := range []uint16{0, 3, uint16(Layout.length(true) - 1), 60000} {
+		garbage := frameWithLength(length)
+		buffer := append(append([]byte(nil), garbage...), valid...)
+
+		ok, from, to, err := packetComplete(buffer, 0, FrameChecksum, true)
+		if err != nil || ok == false || from != len(garbage) || to != len(buffer) {
+			t.Errorf("length %d: got %t [%d:%d] %v, expected the frame at [%d:%d]", length, ok, from, to, err, len(garbage), len(buffer))
+		}
+	}
+}
+
+func TestPacketCompleteIncomplete(t *testing.T) {
+	loadTestDefinitions(t)
+	frame := encodeTestPacket(t, "Waypoint", ObjectCmd, 1, waypointData())
+
+	tests := []struct {
+		buffer []byte
+		ok     bool
+		from   int
+		err    error
+	}{
+		// too short to hold a header, the scan resumes where one could start
+		{[]byte{0x00, 0x01}, false, 0, nil},
+		{append([]byte{0x00, 0x01}, frame[:len(frame)-1]...), false, 2, nil},
+		{append([]byte{0x00, 0x01}, frame...), true, 2, nil},
+		{corruptFrame(frame), false, 0, ErrBadCRC},
+	}
+
+	for i, test := range tests {
+		ok, from, _, err := packetComplete(test.buffer, 0, FrameChecksum, true)
+		if ok != test.ok || from != test.from || err != test.err {
+			t.Errorf("case %d: got %t from %d %v, expected %t from %d %v", i, ok, from, err, test.ok, test.from, test.err)
+		}
+	}
+
+	// the checksum is not verified on trusted links
+	if ok, _, _, err := packetComplete(corruptFrame(frame), 0, FrameChecksum, false); ok == false || err != nil {
+		t.Errorf("unverified checksum: got %t %v", ok, err)
+	}
+}
+
+// advanceUntil advances clock a second at a time until done is closed, for at most max
+func advanceUntil(clock *manualClock, done chan struct{}, max time.Duration) time.Duration {
+	var elapsed time.Duration
+	for elapsed < max {
+		select {
+		case <-done:
+			return elapsed
+		default:
+		}
+		clock.Advance(time.Second)
+		elapsed += time.Second
+		time.Sleep(2 * time.Millisecond)
+	}
+	return elapsed
+}
+
+func TestReadTimeout(t *testing.T) {
+	loadTestDefinitions(t)
+	clock := newManualClock()
+	DefaultClock = clock
+	links, restore := mockLinks()
+	defer func() {
+		DefaultClock, ReadTimeout = systemClock{}, 10*time.Second
+		restore()
+	}()
+	ReadTimeout = 5 * time.Second
+	inChan := make(chan Packet)
+	outChan := make(chan Packet, 1)
+	frame := encodeTestPacket(t, "AttitudeActual", ObjectCmd, 0, attitudeData())
+	closed := func(link *mockLink) bool {
+		link.lock.Lock()
+		defer link.lock.Unlock()
+		if link.usedAfterClose {
+			t.Error("link used after being closed")
+		}
+		return link.closed
+	}
+
+	// the link goes silent after a frame, it is given up on, to be reopened by Start, once ReadTimeout is over
+	done := make(chan struct{})
+	go func() {
+		start(inChan, outChan)
+		close(done)
+	}()
+	link := <-links
+	link.reads <- frame
+	<-outChan
+	if elapsed := advanceUntil(clock, done, time.Minute); elapsed <= ReadTimeout || elapsed == time.Minute {
+		t.Errorf("silent link given up on after %s", elapsed)
+	}
+	if closed(link) == false {
+		t.Error("silent link not closed")
+	}
+
+	// waiting for a slow consumer is not silence
+	done = make(chan struct{})
+	go func() {
+		start(inChan, outChan)
+		close(done)
+	}()
+	link = <-links
+	link.reads <- frame
+	link.reads <- frame
+	for len(link.reads) != 0 || len(outChan) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if elapsed := advanceUntil(clock, done, 3*ReadTimeout); elapsed != 3*ReadTimeout {
+		t.Errorf("link given up on after %s while the reader waits for the consumer", elapsed)
+	}
+
+	// the link is lost while the reader waits for the consumer, the packet it holds is not pushed anymore
+	link.lock.Lock()
+	link.writeErr = io.ErrClosedPipe
+	link.lock.Unlock()
+	inChan <- *NewPacket(AllDefinitions.MustGetDefinitionForName("AttitudeActual"), ObjectRequest, 0, nil)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("link not given up on after a write error")
+	}
+	if closed(link) == false {
+		t.Error("lost link not closed")
+	}
+	<-outChan
+	select {
+	case packet := <-outChan:
+		t.Errorf("%s pushed from a lost link", packet.Definition.Name)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestDefinitionsOverlay(t *testing.T) {
+	source, err := ioutil.ReadFile(filepath.Join("testdata", "label.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay := writeDefinitionsDir(t, map[string]string{
+		"label.xml": strings.Replace(string(source), `type="uint16"`, `type="int32"`, 1),
+		"other.xml": strings.Replace(string(source), `name="Label"`, `name="Other"`, 1),
+	})
+	defer os.RemoveAll(overlay)
+	base := testDefinitions(t)
+
+	tests := []struct {
+		dirs   []string
+		length int
+		count  int
+	}{
+		{[]string{"testdata", overlay}, 13, len(base) + 2},
+		// the last directory wins
+		{[]string{overlay, "testdata"}, 11, len(base) + 2},
+		{[]string{"testdata", "testdata"}, 11, len(base)},
+	}
+	for _, test := range tests {
+		defs, err := newDefinitions(test.dirs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		label := defs.MustGetDefinitionForName("Label")
+		if label.Fields.ByteLength() != test.length || len(defs) != test.count || label.Meta == nil {
+			t.Errorf("%v: Label of %d bytes, %d definitions", test.dirs, label.Fields.ByteLength(), len(defs))
+		}
+		if len(defs.Validate()) != 0 {
+			t.Errorf("%v: %v", test.dirs, defs.Validate())
+		}
+	}
+}
+
+func TestLinkStateHandler(t *testing.T) {
+	loadTestDefinitions(t)
+	links, restore := mockLinks()
+	events := make(chan bool, 4)
+	LinkStateHandler = func(up bool) { events <- up }
+	defer func() {
+		LinkStateHandler = nil
+		restore()
+	}()
+
+	inChan := make(chan Packet)
+	done := make(chan struct{})
+	go func() {
+		start(inChan, make(chan Packet, 1))
+		close(done)
+	}()
+	link := <-links
+	if up := <-events; up == false || LinkUp() == false {
+		t.Errorf("link opened, got event up %t, LinkUp %t", up, LinkUp())
+	}
+
+	link.lock.Lock()
+	link.writeErr = io.ErrClosedPipe
+	link.lock.Unlock()
+	inChan <- *NewPacket(AllDefinitions.MustGetDefinitionForName("AttitudeActual"), ObjectRequest, 0, nil)
+	<-done
+	select {
+	case up := <-events:
+		if up || LinkUp() {
+			t.Errorf("link lost, got event up %t, LinkUp %t", up, LinkUp())
+		}
+	default:
+		t.Error("no event once the link is lost")
+	}
+	if len(events) != 0 {
+		t.Errorf("%d events left", len(events))
+	}
+}
+
+func TestTimestampedFrame(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+
+	frame := encodeTestPacket(t, "Waypoint", ObjectCmd, 2, waypointData())
+	timestamped := timestampFrame(frame, false, 0x1234)
+
+	decoded, err := newPacketFromBinary(timestamped, FrameChecksum.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Definition != definition || decoded.Cmd != ObjectCmd || decoded.InstanceID != 2 || decoded.Timestamp != 0x1234 {
+		t.Errorf("decoded %s cmd %d instance %d timestamp %x", decoded.Definition.Name, decoded.Cmd, decoded.InstanceID, decoded.Timestamp)
+	}
+	if decoded.Data["Action"] != "Loiter" {
+		t.Errorf("decoded %v", decoded.Data)
+	}
+}
+
+func TestDefinitionsDirErrors(t *testing.T) {
+	empty := writeDefinitionsDir(t, nil)
+	defer os.RemoveAll(empty)
+	noXML := writeDefinitionsDir(t, map[string]string{"readme.txt": "objects"})
+	defer os.RemoveAll(noXML)
+	noObject := writeDefinitionsDir(t, map[string]string{"empty.xml": "<xml></xml>"})
+	defer os.RemoveAll(noObject)
+	missing := filepath.Join(empty, "missing")
+
+	tests := []struct {
+		dirs  []string
+		error string
+	}{
+		{[]string{missing}, "Definitions directory " + missing + " does not exist"},
+		{[]string{"testdata", missing}, "Definitions directory " + missing + " does not exist"},
+		{[]string{filepath.Join("testdata", "label.xml")}, "is not a directory"},
+		{[]string{empty}, "Definitions directory " + empty + " is empty"},
+		{[]string{noXML}, "No xml file in definitions directory " + noXML},
+		{[]string{noObject}, filepath.Join(noObject, "empty.xml") + ": no object definition found"},
+	}
+	for _, test := range tests {
+		_, err := newDefinitions(test.dirs...)
+		if err == nil || strings.Contains(err.Error(), test.error) == false {
+			t.Errorf("%v: got %v, expected %q", test.dirs, err, test.error)
+		}
+	}
+}
+
+func TestReloadDefinitions(t *testing.T) {
+	loadTestDefinitions(t)
+	defer loadTestDefinitions(t)
+	defer ClearSamplingPolicy("Label")
+	defer ClearRerequestOnError("Label")
+	label := AllDefinitions.MustGetDefinitionForName("Label")
+	waypoint := AllDefinitions.MustGetDefinitionForName("Waypoint")
+
+	for _, name := range []string{"Label", "Waypoint"} {
+		if err := PollObject(name, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := RerequestOnError(name, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	source, err := ioutil.ReadFile(filepath.Join("testdata", "label.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := writeDefinitionsDir(t, map[string]string{"label.xml": string(source)})
+	defer os.RemoveAll(dir)
+	if err := ReloadDefinitions(dir); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := AllDefinitions.MustGetDefinitionForName("Label")
+	if reloaded == label || reloaded.ObjectID != label.ObjectID {
+		t.Fatalf("Label not reloaded: %p id %#x", reloaded, reloaded.ObjectID)
+	}
+
+	// the policies of Label follow its new definition, the ones of Waypoint are gone with it
+	samplingMutex.Lock()
+	sampling, samplingKept := samplingPolicies[label.ObjectID]
+	_, samplingDropped := samplingPolicies[waypoint.ObjectID]
+	samplingMutex.Unlock()
+	if samplingKept == false || sampling.definition != reloaded {
+		t.Error("Label sampling policy not moved to the reloaded definition")
+	}
+	if samplingDropped {
+		t.Error("Waypoint sampling policy kept after its definition was removed")
+	}
+
+	rerequestMutex.Lock()
+	rerequest, rerequestKept := rerequestPolicies[label.ObjectID&Layout.objectIDMask()]
+	_, rerequestDropped := rerequestPolicies[waypoint.ObjectID&Layout.objectIDMask()]
+	rerequestMutex.Unlock()
+	if rerequestKept == false || rerequest.definition != reloaded {
+		t.Error("Label re-request policy not moved to the reloaded definition")
+	}
+	if rerequestDropped {
+		t.Error("Waypoint re-request policy kept after its definition was removed")
+	}
+
+	// a failed reload keeps the definitions
+	if err := ReloadDefinitions(filepath.Join(dir, "missing")); err == nil {
+		t.Error("reloaded from a missing directory")
+	}
+	if AllDefinitions.MustGetDefinitionForName("Label") != reloaded {
+		t.Error("definitions replaced by a failed reload")
+	}
+}
+
+func TestMetaDefinitionError(t *testing.T) {
+	// meta definitions hold uint8 and uint16 fields, the object itself none
+	defer func(typeInfos TypeIndex) { TypeInfos = typeInfos }(TypeInfos)
+	var withoutUint8 TypeIndex
+	for _, typeInfo := range TypeInfos {
+		if typeInfo.Name != "uint8" {
+			withoutUint8 = append(withoutUint8, typeInfo)
+		}
+	}
+	TypeInfos = withoutUint8
+
+	dir := writeDefinitionsDir(t, map[string]string{"speed.xml": `<xml>
+    <object name="Speed" singleinstance="true" settings="false">
+        <description>A speed.</description>
+        <field name="Value" units="m/s" type="float" elements="1"/>
+    </object>
+</xml>`})
+	defer os.RemoveAll(dir)
+	if _, err := newDefinitions(dir); err == nil || strings.Contains(err.Error(), "uint8") == false {
+		t.Errorf("got %v, expected the meta definition error", err)
+	}
+}
+
+// manyDefinitionFiles writes n copies of the Waypoint definition, each with a name of its own, to a new temporary directory
+func manyDefinitionFiles(tb testing.TB, n int) (dir string, filePaths []string) {
+	source, err := ioutil.ReadFile(filepath.Join("testdata", "waypoint.xml"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	files := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		files[fmt.Sprintf("waypoint%d.xml", i)] = strings.Replace(string(source), `name="Waypoint"`, fmt.Sprintf(`name="Waypoint%d"`, i), 1)
+	}
+	dir = writeDefinitionsDir(tb, files)
+	for i := 0; i < n; i++ {
+		filePaths = append(filePaths, filepath.Join(dir, fmt.Sprintf("waypoint%d.xml", i)))
+	}
+	return dir, filePaths
+}
+
+// parseDefinitionFilesWith parses the files without cache, with procs workers
+func parseDefinitionFilesWith(tb testing.TB, procs int, filePaths []string) []*Definition {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+	defer func() { CacheDefinitionFiles = true }()
+	CacheDefinitionFiles = false
+
+	hash, err := objectIDHash()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	definitions, err := parseDefinitionFiles(filePaths, hash)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return definitions
+}
+
+func TestParseDefinitionFilesConcurrently(t *testing.T) {
+	dir, filePaths := manyDefinitionFiles(t, 40)
+	defer os.RemoveAll(dir)
+
+	sequential := parseDefinitionFilesWith(t, 1, filePaths)
+	concurrent := parseDefinitionFilesWith(t, 8, filePaths)
+	if len(sequential) != len(filePaths) || reflect.DeepEqual(sequential, concurrent) == false {
+		t.Fatalf("%d definitions parsed sequentially, %d concurrently", len(sequential), len(concurrent))
+	}
+	// in the order of the files
+	for i, definition := range concurrent {
+		if definition.Name != fmt.Sprintf("Waypoint%d", i) {
+			t.Errorf("file %d parsed as %s", i, definition.Name)
+		}
+	}
+
+	// the error is the one of the first failing file
+	failing := append(append([]string(nil), filePaths...), filepath.Join(dir, "missing1.xml"), filepath.Join(dir, "missing2.xml"))
+	hash, _ := objectIDHash()
+	if _, err := parseDefinitionFiles(failing, hash); err == nil || strings.Contains(err.Error(), "missing1.xml") == false {
+		t.Errorf("got %v", err)
+	}
+}
+
+func benchmarkParseDefinitionFiles(b *testing.B, procs int) {
+	dir, filePaths := manyDefinitionFiles(b, 100)
+	defer os.RemoveAll(dir)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parseDefinitionFilesWith(b, procs, filePaths)
+	}
+}
+
+func BenchmarkParseDefinitionFiles(b *testing.B) {
+	benchmarkParseDefinitionFiles(b, runtime.NumCPU())
+}
+
+func BenchmarkParseDefinitionFilesSequential(b *testing.B) {
+	benchmarkParseDefinitionFiles(b, 1)
+}
+
+func TestRegisterDefinition(t *testing.T) {
+	loadTestDefinitions(t)
+	source, err := ioutil.ReadFile(filepath.Join("testdata", "label.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := writeDefinitionsDir(t, map[string]string{"announced.xml": strings.Replace(string(source), `name="Label"`, `name="Announced"`, 1)})
+	defer os.RemoveAll(dir)
+	loaded, err := newDefinitions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// as announced by another connection, its id computed on registration
+	announced := &Definition{Name: "Announced", SingleInstance: true, Settings: true, Fields: FieldsSlice{
+		{Name: "Text", Type: "string", Elements: 8},
+		{Name: "Value", Type: "uint16", Elements: 1},
+		{Name: "Enabled", Type: "enum", Elements: 1, OptionsAttr: "False,True"},
+	}}
+	if err := RegisterDefinition(announced); err != nil {
+		t.Fatal(err)
+	}
+	if expected := loaded.MustGetDefinitionForName("Announced").ObjectID; announced.ObjectID != expected || announced.Meta.ObjectID != expected|1 {
+		t.Errorf("registered with id %#x, meta %#x, expected %#x as loaded from xml", announced.ObjectID, announced.Meta.ObjectID, expected)
+	}
+
+	frame := encodeTestPacket(t, "Announced", ObjectCmd, 0, labelData())
+	decoded, err := newPacketFromBinary(frame, FrameChecksum.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Definition != announced || decoded.Data["Text"] != "quad450" {
+		t.Errorf("decoded %s %v", decoded.Definition.Name, decoded.Data)
+	}
+
+	waypoint := AllDefinitions.MustGetDefinitionForName("Waypoint")
+	count := len(AllDefinitions)
+	tests := []*Definition{
+		testDefinition("announced", 0, &FieldDefinition{Name: "Value", Type: "uint8"}),
+		testDefinition("SameID", waypoint.ObjectID, &FieldDefinition{Name: "Value", Type: "uint8"}),
+		testDefinition("SameMetaID", waypoint.ObjectID^1, &FieldDefinition{Name: "Value", Type: "uint8"}),
+		testDefinition("Unknown", 0, &FieldDefinition{Name: "Value", Type: "uint128"}),
+	}
+	for _, definition := range tests {
+		if err := RegisterDefinition(definition); err == nil {
+			t.Errorf("%s registered with id %#x", definition.Name, definition.ObjectID)
+		}
+	}
+	if len(AllDefinitions) != count {
+		t.Errorf("%d definitions after the rejected ones, %d before", len(AllDefinitions), count)
+	}
+}
+
+func TestMaxObjectBodySize(t *testing.T) {
+	loadTestDefinitions(t)
+	defer func() { MaxObjectBodySize = 0 }()
+	frame := encodeTestPacket(t, "Waypoint", ObjectCmd, 1, waypointData())
+	timestamped := timestampFrame(frame, false, 0x1234)
+	body := AllDefinitions.MustGetDefinitionForName("Waypoint").Fields.ByteLength()
+
+	tests := []struct {
+		max   int
+		frame []byte
+		ok    bool
+	}{
+		{0, frame, true},
+		{body, frame, true},
+		{body - 1, frame, false},
+		{body, timestamped, true},
+		{body - 1, timestamped, false},
+	}
+	for _, test := range tests {
+		MaxObjectBodySize = test.max
+		// the sync byte of a frame too long is taken as garbage
+		ok, from, _, err := packetComplete(test.frame, 0, FrameChecksum, true)
+		if ok != test.ok || err != nil || (ok && from != 0) {
+			t.Errorf("max %d, frame of %d bytes: got %t from %d %v", test.max, len(test.frame), ok, from, err)
+		}
+	}
+}
+
+func TestPassUnknownObjects(t *testing.T) {
+	loadTestDefinitions(t)
+	defer func() { PassUnknownObjects = false }()
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+
+	frame := encodeTestPacket(t, "Waypoint", ObjectCmdWithAck, 1, waypointData())
+	unknown := append([]byte(nil), frame[:len(frame)-1]...)
+	unknown[4] ^= 0x02
+	unknown = sealFrame(unknown)
+
+	if _, err := newPacketFromBinary(unknown, FrameChecksum.Size()); ErrorKind(err) != ErrUnknownObject {
+		t.Errorf("unknown object decoded with error %v", err)
+	}
+
+	PassUnknownObjects = true
+	packet, err := newPacketFromBinary(unknown, FrameChecksum.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the instance id is part of the raw data, there is no telling whether the object has one
+	if packet.Definition != nil || packet.ObjectID != definition.ObjectID^0x02 || packet.Cmd != ObjectCmdWithAck ||
+		bytes.Equal(packet.RawData, unknown[4+Layout.ObjectIDSize:len(unknown)-1]) == false {
+		t.Errorf("decoded object %#x cmd %d raw data %x", packet.ObjectID, packet.Cmd, packet.RawData)
+	}
+
+	// without definition, it can be forwarded but neither sent back nor flattened
+	if _, err := packet.toBinary(); ErrorKind(err) != ErrUnknownObject {
+		t.Errorf("packet without definition encoded with error %v", err)
+	}
+	if flat := Flatten(packet); len(flat) != 0 {
+		t.Errorf("flattened %v without definition", flat)
+	}
+}
@@ -0,0 +1,159 @@
+package uavtalk
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+/**
+ * DecodeToJSON writes the body of an object straight as JSON, for pipelines forwarding packets as JSON.
+ * The output is the one of json.Marshal on the decoded map: keys sorted, enums as their option names
+ * (or bools, see BooleanEnums), named elements as objects, other arrays as arrays.
+ */
+
+// namedIndexes sorts indexes by name, as json.Marshal sorts map keys
+type namedIndexes struct {
+	names   []string
+	indexes []int
+}
+
+func newNamedIndexes(names []string) *namedIndexes {
+	sorted := &namedIndexes{names, make([]int, len(names))}
+	for i := range names {
+		sorted.indexes[i] = i
+	}
+	sort.Sort(sorted)
+	return sorted
+}
+
+func (s *namedIndexes) Len() int           { return len(s.indexes) }
+func (s *namedIndexes) Less(i, j int) bool { return s.names[s.indexes[i]] < s.names[s.indexes[j]] }
+func (s *namedIndexes) Swap(i, j int)      { s.indexes[i], s.indexes[j] = s.indexes[j], s.indexes[i] }
+
+// DecodeToJSON decodes body into a JSON object, without building the map of the decoded values
+func DecodeToJSON(definition *Definition, body []byte) ([]byte, error) {
+	byteLength := definition.Fields.ByteLength()
+	if len(body) < byteLength {
+		return nil, newCodecError(ErrShortBuffer, "%s: body too short, %d bytes instead of %d", definition.Name, len(body), byteLength)
+	}
+
+	names := make([]string, len(definition.Fields))
+	offsets := make([]int, len(definition.Fields))
+	offset := 0
+	for i, field := range definition.Fields {
+		names[i] = field.Name
+		offsets[i] = offset
+		offset += field.FieldTypeInfo.Size * field.Elements
+	}
+
+	out := new(bytes.Buffer)
+	reader := readerPool.Get().(*bytes.Reader)
+	defer putReader(reader)
+
+	out.WriteByte('{')
+	for n, i := range newNamedIndexes(names).indexes {
+		if n > 0 {
+			out.WriteByte(',')
+		}
+		if err := writeJSONValue(out, names[i]); err != nil {
+			return nil, err
+		}
+		out.WriteByte(':')
+		reader.Reset(body[offsets[i]:])
+		if err := writeJSONField(out, definition.Fields[i], reader); err != nil {
+			return nil, err
+		}
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+// writeJSONField decodes a field from reader and writes it as uAVTalkToInterface would return it
+func writeJSONField(out *bytes.Buffer, field *FieldDefinition, reader *bytes.Reader) error {
+	if field.FieldTypeInfo.Name == "string" {
+		value, err := readStringFromUAVTalk(field, reader)
+		if err != nil {
+			return err
+		}
+		return writeJSONValue(out, value)
+	}
+
+	if field.Elements == 1 {
+		value, err := readFromUAVTalk(field, reader)
+		if err != nil {
+			return err
+		}
+		return writeJSONValue(out, value)
+	}
+
+	if len(field.ElementNames) == 0 {
+		out.WriteByte('[')
+		for i := 0; i < field.Elements; i++ {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			value, err := readFromUAVTalk(field, reader)
+			if err != nil {
+				return err
+			}
+			if err := writeJSONValue(out, value); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+		return nil
+	}
+
+	values := make([]interface{}, field.Elements)
+	for i := range values {
+		value, err := readFromUAVTalk(field, reader)
+		if err != nil {
+			return err
+		}
+		values[i] = value
+	}
+	out.WriteByte('{')
+	for n, i := range newNamedIndexes(field.ElementNames).indexes {
+		if n > 0 {
+			out.WriteByte(',')
+		}
+		if err := writeJSONValue(out, field.ElementNames[i]); err != nil {
+			return err
+		}
+		out.WriteByte(':')
+		if err := writeJSONValue(out, values[i]); err != nil {
+			return err
+		}
+	}
+	out.WriteByte('}')
+	return nil
+}
+
+// writeJSONValue writes a decoded element, integers are formatted directly, the rest goes through json.Marshal
+func writeJSONValue(out *bytes.Buffer, value interface{}) error {
+	var scratch [20]byte
+	var b []byte
+	switch v := value.(type) {
+	case int8:
+		b = strconv.AppendInt(scratch[:0], int64(v), 10)
+	case int16:
+		b = strconv.AppendInt(scratch[:0], int64(v), 10)
+	case int32:
+		b = strconv.AppendInt(scratch[:0], int64(v), 10)
+	case uint8:
+		b = strconv.AppendUint(scratch[:0], uint64(v), 10)
+	case uint16:
+		b = strconv.AppendUint(scratch[:0], uint64(v), 10)
+	case uint32:
+		b = strconv.AppendUint(scratch[:0], uint64(v), 10)
+	default:
+		var err error
+		if b, err = json.Marshal(value); err != nil {
+			return err
+		}
+	}
+	out.Write(b)
+	return nil
+}
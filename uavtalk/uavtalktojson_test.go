@@ -0,0 +1,52 @@
+package uavtalk
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeToJSON(t *testing.T) {
+	loadTestDefinitions(t)
+	defer func() { BooleanEnums = false }()
+
+	tests := []struct {
+		name       string
+		instanceID uint16
+		data       map[string]interface{}
+	}{
+		{"AttitudeActual", 0, attitudeData()},
+		{"Waypoint", 4, waypointData()},
+		{"Label", 0, labelData()},
+	}
+
+	for _, booleanEnums := range []bool{false, true} {
+		BooleanEnums = booleanEnums
+		for _, test := range tests {
+			definition := AllDefinitions.MustGetDefinitionForName(test.name)
+			frame := encodeTestPacket(t, test.name, ObjectCmd, test.instanceID, test.data)
+			body := frame[Layout.length(definition.SingleInstance) : len(frame)-1]
+
+			decoded, err := uAVTalkToMap(definition, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := json.Marshal(decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := DecodeToJSON(definition, body)
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+				continue
+			}
+			if bytes.Equal(result, expected) == false {
+				t.Errorf("%s BooleanEnums %t:\n%s\nexpected\n%s", test.name, booleanEnums, result, expected)
+			}
+
+			if _, err := DecodeToJSON(definition, body[:len(body)-1]); ErrorKind(err) != ErrShortBuffer {
+				t.Errorf("%s: short body decoded with error %v", test.name, err)
+			}
+		}
+	}
+}
@@ -4,71 +4,116 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+// readerPool holds the readers used by DecodeInto
+var readerPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Reader)
+	},
+}
+
+// putReader returns a reader to readerPool, without keeping the body it was reading alive
+func putReader(reader *bytes.Reader) {
+	reader.Reset(nil)
+	readerPool.Put(reader)
+}
+
+// readFromUAVTalk reads a single element of field, values are decoded from a stack buffer
+// instead of binary.Read, which allocates on every call.
 func readFromUAVTalk(field *FieldDefinition, reader *bytes.Reader) (interface{}, error) {
 	typeInfo := field.FieldTypeInfo
+
+	var scratch [4]byte
+	b := scratch[:typeInfo.Size]
+	if n, _ := reader.Read(b); n != len(b) {
+		return nil, newCodecError(ErrShortBuffer, "%s: unexpected end of body", field.Name)
+	}
+
 	var result interface{}
 	switch typeInfo.Name {
 	case "int8":
-		tmp := uint8(0)
-		if err := binary.Read(reader, binary.LittleEndian, &tmp); err != nil {
-			return nil, err
-		}
-		result = tmp
+		result = int8(b[0])
 	case "int16":
-		tmp := uint16(0)
-		if err := binary.Read(reader, binary.LittleEndian, &tmp); err != nil {
-			return nil, err
-		}
-		result = tmp
+		result = int16(binary.LittleEndian.Uint16(b))
 	case "int32":
-		tmp := int32(0)
-		if err := binary.Read(reader, binary.LittleEndian, &tmp); err != nil {
-			return nil, err
-		}
-		result = tmp
+		result = int32(binary.LittleEndian.Uint32(b))
 	case "uint8":
-		tmp := uint8(0)
-		if err := binary.Read(reader, binary.LittleEndian, &tmp); err != nil {
-			return nil, err
-		}
-		result = tmp
+		result = b[0]
 	case "uint16":
-		tmp := uint16(0)
-		if err := binary.Read(reader, binary.LittleEndian, &tmp); err != nil {
-			return nil, err
-		}
-		result = tmp
+		result = binary.LittleEndian.Uint16(b)
 	case "uint32":
-		tmp := uint32(0)
-		if err := binary.Read(reader, binary.LittleEndian, &tmp); err != nil {
-			return nil, err
-		}
-		result = tmp
+		result = binary.LittleEndian.Uint32(b)
 	case "float":
-		tmp := float32(0)
-		if err := binary.Read(reader, binary.LittleEndian, &tmp); err != nil {
-			return nil, err
-		}
-		result = tmp
+		result = math.Float32frombits(binary.LittleEndian.Uint32(b))
 	case "enum":
-		tmp := uint8(0)
-		if err := binary.Read(reader, binary.LittleEndian, &tmp); err != nil {
-			return nil, err
-		}
-		result = tmp
+		result = b[0]
 	default:
 		return nil, errors.New("Could not read from typeInfo.")
 	}
 
 	if typeInfo.Name == "enum" {
-		result = field.Options[result.(uint8)] // haha
+		if falseIndex, trueIndex, ok := booleanEnumIndexes(field); BooleanEnums && ok {
+			switch result.(uint8) {
+			case falseIndex:
+				return false, nil
+			case trueIndex:
+				return true, nil
+			}
+		}
+		index := result.(uint8)
+		if int(index) >= len(field.Options) {
+			return nil, fmt.Errorf("%s: %d is not the index of one of the %d enum options", field.Name, index, len(field.Options))
+		}
+		result = field.Options[index]
 	}
 	return result, nil
 }
 
+// BooleanEnums makes two options enums with boolean like options (False/True, No/Yes, Off/On, Disabled/Enabled)
+// decode to bool instead of the option name. Such enums accept a bool when encoding whatever this setting.
+var BooleanEnums = false
+
+var booleanOptions = [][2]string{{"false", "true"}, {"no", "yes"}, {"off", "on"}, {"disabled", "enabled"}}
+
+// booleanEnumIndexes returns the indexes of the false and true options of a boolean like enum
+func booleanEnumIndexes(field *FieldDefinition) (uint8, uint8, bool) {
+	if len(field.Options) != 2 {
+		return 0, 0, false
+	}
+	first, second := strings.ToLower(field.Options[0]), strings.ToLower(field.Options[1])
+	for _, options := range booleanOptions {
+		if first == options[0] && second == options[1] {
+			return 0, 1, true
+		} else if first == options[1] && second == options[0] {
+			return 1, 0, true
+		}
+	}
+	return 0, 0, false
+}
+
+// readStringFromUAVTalk reads the whole buffer of a string field, the string ends at the first NUL
+func readStringFromUAVTalk(field *FieldDefinition, reader *bytes.Reader) (interface{}, error) {
+	b := make([]byte, field.Elements)
+	if n, _ := reader.Read(b); n != len(b) {
+		return nil, newCodecError(ErrShortBuffer, "%s: unexpected end of body", field.Name)
+	}
+	if end := bytes.IndexByte(b, 0); end >= 0 {
+		b = b[:end]
+	}
+	return string(b), nil
+}
+
 func uAVTalkToInterface(field *FieldDefinition, reader *bytes.Reader) (interface{}, error) {
+	if field.FieldTypeInfo.Name == "string" {
+		return readStringFromUAVTalk(field, reader)
+	}
+
 	var result interface{}
 	if field.Elements > 1 && len(field.ElementNames) == 0 {
 		resultArray := make([]interface{}, field.Elements)
@@ -101,16 +146,113 @@ func uAVTalkToInterface(field *FieldDefinition, reader *bytes.Reader) (interface
 }
 
 func uAVTalkToMap(uavdef *Definition, data []byte) (map[string]interface{}, error) {
-	reader := bytes.NewReader(data)
-	result := make(map[string]interface{})
+	result := make(map[string]interface{}, len(uavdef.Fields))
+	if err := DecodeInto(uavdef, data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DecodeInto decodes the body of an object into dst, which is emptied first so it can be reused from one packet to the other.
+// The values are the ones of the Data of a received packet, arrays and named elements still being allocated.
+func DecodeInto(definition *Definition, body []byte, dst map[string]interface{}) error {
+	for name := range dst {
+		delete(dst, name)
+	}
+
+	reader := readerPool.Get().(*bytes.Reader)
+	reader.Reset(body)
+	defer putReader(reader)
 
-	for _, field := range uavdef.Fields {
+	for _, field := range definition.Fields {
 		value, err := uAVTalkToInterface(field, reader)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		result[field.Name] = value
+		dst[field.Name] = value
 	}
 
-	return result, nil
+	return nil
+}
+
+// Flatten returns the data of a decoded packet with one key per field element, named after the object, the field and the element,
+// as in AttitudeActual.Roll or Waypoint.Position.North. Elements of arrays without element names are numbered from 0.
+// The instance id is not part of the keys, and the map is empty for unknown objects, see PassUnknownObjects.
+func Flatten(packet *Packet) map[string]interface{} {
+	definition := packet.Definition
+	if definition == nil {
+		return map[string]interface{}{}
+	}
+	result := make(map[string]interface{}, len(definition.Fields))
+	for _, field := range definition.Fields {
+		value, ok := packet.Data[field.Name]
+		if ok == false {
+			continue
+		}
+		key := definition.Name + "." + field.Name
+		if field.Elements == 1 || field.FieldTypeInfo.Name == "string" {
+			result[key] = value
+			continue
+		}
+		for i, element := range fieldElements(field, value) {
+			if len(field.ElementNames) > 0 {
+				result[key+"."+field.ElementNames[i]] = element
+			} else {
+				result[key+"."+strconv.Itoa(i)] = element
+			}
+		}
+	}
+	return result
+}
+
+// PeekField decodes a single field from the body of an object, without decoding the other fields
+func PeekField(objectID uint32, fieldName string, body []byte) (interface{}, error) {
+	definition, err := AllDefinitions.GetDefinitionForObjectID(objectID)
+	if err != nil {
+		return nil, err
+	}
+	return decodeField(definition, fieldName, body)
+}
+
+// decodeField decodes the field with the given name found at its offset in body
+func decodeField(definition *Definition, fieldName string, body []byte) (interface{}, error) {
+	field, offset, err := definition.Fields.FieldOffset(fieldName)
+	if err != nil {
+		return nil, err
+	}
+	end := offset + field.FieldTypeInfo.Size*field.Elements
+	if len(body) < end {
+		return nil, newCodecError(ErrShortBuffer, "%s: body too short for field %s, %d bytes instead of at least %d", definition.Name, fieldName, len(body), end)
+	}
+
+	reader := readerPool.Get().(*bytes.Reader)
+	reader.Reset(body[offset:end])
+	defer putReader(reader)
+	return uAVTalkToInterface(field, reader)
+}
+
+// LazyObject decodes the fields of a body on first access only, each field being decoded once
+type LazyObject struct {
+	Definition *Definition
+
+	body   []byte
+	values map[string]interface{}
+}
+
+// NewLazyObject returns a LazyObject over body, which must not be modified while the object is used
+func NewLazyObject(definition *Definition, body []byte) *LazyObject {
+	return &LazyObject{Definition: definition, body: body, values: make(map[string]interface{})}
+}
+
+// Field returns the value of the field with the given name, as it would be in the Data of a decoded packet
+func (object *LazyObject) Field(name string) (interface{}, error) {
+	if value, ok := object.values[name]; ok {
+		return value, nil
+	}
+	value, err := decodeField(object.Definition, name, object.body)
+	if err != nil {
+		return nil, err
+	}
+	object.values[name] = value
+	return value, nil
 }
@@ -0,0 +1,240 @@
+package uavtalk
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeInto(t *testing.T) {
+	loadTestDefinitions(t)
+
+	tests := []struct {
+		name string
+		data map[string]interface{}
+	}{
+		{"Waypoint", waypointData()},
+		{"AttitudeActual", attitudeData()},
+		{"Label", labelData()},
+		{"Waypoint", waypointData()},
+	}
+
+	// the same map is reused, fields of the previous object must not be left over
+	dst := map[string]interface{}{"Stale": true}
+	for _, test := range tests {
+		definition := AllDefinitions.MustGetDefinitionForName(test.name)
+		body := testBody(t, test.name, test.data)
+		expected, err := uAVTalkToMap(definition, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := DecodeInto(definition, body, dst); err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(dst, expected) == false {
+			t.Errorf("%s: decoded %v, expected %v", test.name, dst, expected)
+		}
+	}
+
+	definition := AllDefinitions.MustGetDefinitionForName("AttitudeActual")
+	if err := DecodeInto(definition, testBody(t, "AttitudeActual", attitudeData())[:10], dst); ErrorKind(err) != ErrShortBuffer {
+		t.Errorf("short body decoded with error %v", err)
+	}
+}
+
+func TestSignedIntegers(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+
+	tests := []struct {
+		sign     int8
+		counter  [2]int16
+		distance int32
+	}{
+		{math.MinInt8, [2]int16{math.MinInt16, math.MaxInt16}, math.MinInt32},
+		{math.MaxInt8, [2]int16{math.MaxInt16, math.MinInt16}, math.MaxInt32},
+		{-1, [2]int16{-1, 1}, -1},
+		{0, [2]int16{0, 0}, 0},
+	}
+	for _, test := range tests {
+		data := waypointData()
+		data["Sign"] = float64(test.sign)
+		data["Counter"] = []interface{}{float64(test.counter[0]), float64(test.counter[1])}
+		data["Distance"] = float64(test.distance)
+
+		decoded, err := uAVTalkToMap(definition, testBody(t, "Waypoint", data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		counter := []interface{}{test.counter[0], test.counter[1]}
+		if decoded["Sign"] != test.sign || reflect.DeepEqual(decoded["Counter"], counter) == false || decoded["Distance"] != test.distance {
+			t.Errorf("%+v: decoded Sign %v, Counter %v, Distance %v", test, decoded["Sign"], decoded["Counter"], decoded["Distance"])
+		}
+	}
+}
+
+func TestPeekField(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+	body := testBody(t, "Waypoint", waypointData())
+	object := NewLazyObject(definition, body)
+
+	tests := []struct {
+		field    string
+		expected interface{}
+	}{
+		{"Sign", int8(-1)},
+		{"Action", "Loiter"},
+		{"Counter", []interface{}{int16(-32768), int16(32767)}},
+		{"Position", map[string]interface{}{"North": float32(1.5), "East": float32(-2), "Down": float32(-10.25)}},
+	}
+	for _, test := range tests {
+		value, err := PeekField(definition.ObjectID, test.field, body)
+		if err != nil || reflect.DeepEqual(value, test.expected) == false {
+			t.Errorf("PeekField %s: got %v %v", test.field, value, err)
+		}
+		for i := 0; i < 2; i++ {
+			value, err = object.Field(test.field)
+			if err != nil || reflect.DeepEqual(value, test.expected) == false {
+				t.Errorf("LazyObject %s: got %v %v", test.field, value, err)
+			}
+		}
+	}
+
+	if _, err := PeekField(definition.ObjectID, "Heading", body); err == nil {
+		t.Error("peeked an unknown field")
+	}
+	if _, err := PeekField(definition.ObjectID, "Sign", body[:len(body)-1]); ErrorKind(err) != ErrShortBuffer {
+		t.Errorf("peeked a short body with error %v", err)
+	}
+}
+
+func TestDecodeEnumIndex(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Label")
+	_, offset, err := definition.Fields.FieldOffset("Enabled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { BooleanEnums = false }()
+
+	tests := []struct {
+		index        byte
+		booleanEnums bool
+		expected     interface{}
+	}{
+		{0, false, "False"},
+		{1, false, "True"},
+		{0, true, false},
+		{1, true, true},
+		{2, false, nil},
+		{255, true, nil},
+	}
+	for _, test := range tests {
+		BooleanEnums = test.booleanEnums
+		body := testBody(t, "Label", labelData())
+		body[offset] = test.index
+		data, err := uAVTalkToMap(definition, body)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("index %d: decoded as %v", test.index, data["Enabled"])
+			}
+			continue
+		}
+		if err != nil || data["Enabled"] != test.expected {
+			t.Errorf("index %d, BooleanEnums %t: decoded %v %v", test.index, test.booleanEnums, data["Enabled"], err)
+		}
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+	data, err := uAVTalkToMap(definition, testBody(t, "Waypoint", waypointData()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flat := Flatten(&Packet{Definition: definition, Data: data})
+	expected := map[string]interface{}{
+		"Waypoint.Position.North": float32(1.5),
+		"Waypoint.Position.East":  float32(-2),
+		"Waypoint.Position.Down":  float32(-10.25),
+		"Waypoint.Velocity":       float32(3),
+		"Waypoint.Distance":       int32(-2147483648),
+		"Waypoint.Action":         "Loiter",
+		"Waypoint.Modes.0":        "On",
+		"Waypoint.Modes.1":        "Off",
+		"Waypoint.Modes.2":        "On",
+		"Waypoint.Modes.3":        "Off",
+		"Waypoint.Counter.0":      int16(-32768),
+		"Waypoint.Counter.1":      int16(32767),
+		"Waypoint.Sign":           int8(-1),
+	}
+	if reflect.DeepEqual(flat, expected) == false {
+		t.Errorf("flattened %v", flat)
+	}
+}
+
+func TestEnumArrays(t *testing.T) {
+	loadTestDefinitions(t)
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+	field, offset, err := definition.Fields.FieldOffset("Modes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each element is resolved on its own, whatever its form
+	data := waypointData()
+	data["Modes"] = []interface{}{"On", float64(0), true, "1"}
+	body := testBody(t, "Waypoint", data)
+	decoded, err := uAVTalkToMap(definition, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []interface{}{"On", "Off", "On", "On"}; reflect.DeepEqual(decoded["Modes"], expected) == false {
+		t.Errorf("decoded %v, expected %v", decoded["Modes"], expected)
+	}
+
+	body[offset+2] = byte(len(field.Options))
+	if _, err := uAVTalkToMap(definition, body); err == nil {
+		t.Error("decoded an element past the options")
+	}
+
+	for _, modes := range []interface{}{[]interface{}{"On", "Off", "On"}, []interface{}{"On", "Off", "On", "Off", "On"}, "On"} {
+		data["Modes"] = modes
+		if _, err := mapToUAVTalk(definition, data); err == nil {
+			t.Errorf("encoded %v", modes)
+		}
+	}
+}
+
+func BenchmarkUAVTalkToMap(b *testing.B) {
+	loadTestDefinitions(b)
+	definition := AllDefinitions.MustGetDefinitionForName("AttitudeActual")
+	body := testBody(b, "AttitudeActual", attitudeData())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := uAVTalkToMap(definition, body); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDecodeInto is BenchmarkUAVTalkToMap decoding into the same map, its allocations are the boxed values only
+func BenchmarkDecodeInto(b *testing.B) {
+	loadTestDefinitions(b)
+	definition := AllDefinitions.MustGetDefinitionForName("AttitudeActual")
+	body := testBody(b, "AttitudeActual", attitudeData())
+	dst := make(map[string]interface{}, len(definition.Fields))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := DecodeInto(definition, body, dst); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,19 +1,96 @@
 package uavtalk
 
 import (
+	"bytes"
 	"fmt"
 
 	log "github.com/Sirupsen/logrus"
 )
 
+var cmdNames = []string{"ObjectCmd", "ObjectRequest", "ObjectCmdWithAck", "ObjectAck", "ObjectNack"}
+
 // PrintHex prints the content of the buffer as hex
 func PrintHex(buffer []byte, n int) {
+	log.Info(hexString(buffer[:n]))
+}
+
+func hexString(buffer []byte) string {
 	l := ""
-	for i := 0; i < n; i++ {
+	for i, b := range buffer {
 		if i > 0 {
 			l += ":"
 		}
-		l += fmt.Sprintf("%.02x", buffer[i])
+		l += fmt.Sprintf("%.02x", b)
+	}
+	return l
+}
+
+// FormatFrame returns a human readable breakdown of a binary frame: the hex dump,
+// then one line per header part, one line per decoded field and the crc.
+// Whatever can't be decoded is reported in place of the remaining lines.
+func FormatFrame(frame []byte) string {
+	out := new(bytes.Buffer)
+	fmt.Fprintf(out, "frame       %s\n", hexString(frame))
+
+	headerLength := Layout.length(true)
+	checksumSize := FrameChecksum.Size()
+	if len(frame) < headerLength+checksumSize {
+		fmt.Fprintf(out, "error       frame too short (%d bytes)\n", len(frame))
+		return out.String()
+	}
+
+	cmd := (frame[1] &^ timestampedMask) ^ versionMask
+	cmdName := "unknown"
+	if int(cmd) < len(cmdNames) {
+		cmdName = cmdNames[cmd]
+	}
+	fmt.Fprintf(out, "sync        %.02x\n", frame[0])
+	fmt.Fprintf(out, "type        %.02x %s\n", frame[1], cmdName)
+	fmt.Fprintf(out, "length      %d\n", byteArrayToInt16(frame[2:4]))
+
+	objectID := Layout.readObjectID(frame)
+	definition, err := Layout.definitionForObjectID(AllDefinitions, objectID)
+	if err != nil {
+		fmt.Fprintf(out, "object id   %d unknown\n", objectID)
+		return out.String()
+	}
+	fmt.Fprintf(out, "object id   %d %s\n", objectID, definition.Name)
+
+	headerLength = Layout.length(definition.SingleInstance)
+	if len(frame) < headerLength+checksumSize {
+		fmt.Fprintf(out, "error       frame too short for its header (%d bytes)\n", len(frame))
+		return out.String()
+	}
+	if definition.SingleInstance == false {
+		fmt.Fprintf(out, "instance id %d\n", Layout.readInstanceID(frame))
+	}
+	if frame[1]&timestampedMask != 0 {
+		if len(frame) < headerLength+timestampLength+checksumSize {
+			fmt.Fprintf(out, "error       frame too short for its timestamp (%d bytes)\n", len(frame))
+			return out.String()
+		}
+		fmt.Fprintf(out, "timestamp   %d\n", byteArrayToInt16(frame[headerLength:headerLength+timestampLength]))
+		headerLength += timestampLength
+	}
+
+	body := frame[headerLength : len(frame)-checksumSize]
+	if cmd == ObjectCmd || cmd == ObjectCmdWithAck {
+		data, err := uAVTalkToMap(definition, body)
+		if err != nil {
+			fmt.Fprintf(out, "error       %s\n", err)
+		} else {
+			for _, field := range definition.Fields {
+				fmt.Fprintf(out, "field       %s = %v\n", field.Name, data[field.Name])
+			}
+		}
+	}
+
+	crc := frame[len(frame)-checksumSize:]
+	expected := FrameChecksum.Sum(frame[:len(frame)-checksumSize])
+	if bytes.Equal(crc, expected) {
+		fmt.Fprintf(out, "crc         %s ok\n", hexString(crc))
+	} else {
+		fmt.Fprintf(out, "crc         %s wrong, expected %s\n", hexString(crc), hexString(expected))
 	}
-	log.Info(l)
+	return out.String()
 }
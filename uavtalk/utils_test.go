@@ -0,0 +1,33 @@
+package uavtalk
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatFrame(t *testing.T) {
+	loadTestDefinitions(t)
+
+	frame := encodeTestPacket(t, "Waypoint", ObjectCmd, 6, waypointData())
+	corrupted := corruptFrame(frame)
+	unknown := append([]byte(nil), frame...)
+	unknown[4] ^= 0x02
+
+	tests := []struct {
+		frame []byte
+		lines []string
+	}{
+		{frame, []string{"type        20 ObjectCmd", "instance id 6", "field       Action = Loiter", "field       Sign = -1", " ok\n"}},
+		{corrupted, []string{"field       Velocity = 3", "wrong, expected"}},
+		{unknown, []string{"unknown\n"}},
+		{frame[:5], []string{"frame too short (5 bytes)"}},
+	}
+	for i, test := range tests {
+		formatted := FormatFrame(test.frame)
+		for _, line := range test.lines {
+			if strings.Contains(formatted, line) == false {
+				t.Errorf("case %d: %q not found in\n%s", i, line, formatted)
+			}
+		}
+	}
+}
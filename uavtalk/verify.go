@@ -0,0 +1,135 @@
+package uavtalk
+
+import (
+	"fmt"
+	"math"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// VerifyEncoding makes every packet sent be decoded back and compared to its data, mismatches are logged as errors.
+// It is expensive, meant for tracking down encoding bugs.
+var VerifyEncoding = false
+
+// encodeForLink encodes packet with the checksum of the link, and verifies the frame when VerifyEncoding is set
+func encodeForLink(packet *Packet, checksum Checksum) ([]byte, error) {
+	frame, err := packet.encode(packet.Cmd|versionMask, checksum)
+	if err != nil {
+		return nil, err
+	}
+	if VerifyEncoding {
+		if err := verifyEncoding(packet, frame, checksum); err != nil {
+			log.Errorf("ENCODING CHECK FAILED for %s: %s", packet.Definition.Name, err)
+			PrintHex(frame, len(frame))
+		}
+	}
+	return frame, nil
+}
+
+// verifyEncoding decodes frame, ending with checksum, and checks it carries the header and data of packet
+func verifyEncoding(packet *Packet, frame []byte, checksum Checksum) error {
+	decoded, err := newPacketFromBinary(frame, checksum.Size())
+	if err != nil {
+		return err
+	}
+	// compared by id, the definitions may have been reloaded since the packet was created,
+	// as written in the header, which may not hold the whole id
+	objectID := packet.ObjectID
+	if packet.Definition != nil {
+		objectID = packet.Definition.ObjectID
+	}
+	if decoded.ObjectID != objectID&Layout.objectIDMask() || decoded.Cmd != packet.Cmd || decoded.InstanceID != packet.InstanceID {
+		return fmt.Errorf("header decoded as object %d cmd %d instance %d", decoded.ObjectID, decoded.Cmd, decoded.InstanceID)
+	}
+	if packet.Cmd != ObjectCmd && packet.Cmd != ObjectCmdWithAck {
+		return nil
+	}
+	if packet.Definition == nil || decoded.Definition == nil {
+		return fmt.Errorf("object %d: body not decoded, unknown object", objectID)
+	}
+
+	for _, field := range packet.Definition.Fields {
+		sources := fieldElements(field, packet.Data[field.Name])
+		values := fieldElements(field, decoded.Data[field.Name])
+		if len(sources) != len(values) {
+			return fmt.Errorf("%s: %d elements decoded instead of %d", field.Name, len(values), len(sources))
+		}
+		for i := range sources {
+			if sameValue(field, sources[i], values[i]) == false {
+				return fmt.Errorf("%s: %v decoded as %v", field.Name, sources[i], values[i])
+			}
+		}
+	}
+	return nil
+}
+
+// fieldElements returns the elements of a field value in wire order
+func fieldElements(field *FieldDefinition, value interface{}) []interface{} {
+	if field.FieldTypeInfo.Name == "string" {
+		return []interface{}{value}
+	}
+	if field.Elements > 1 && len(field.ElementNames) == 0 {
+		elements, _ := value.([]interface{})
+		return elements
+	} else if field.Elements > 1 {
+		valueMap, _ := value.(map[string]interface{})
+		elements := make([]interface{}, 0, len(field.ElementNames))
+		for _, name := range field.ElementNames {
+			elements = append(elements, valueMap[name])
+		}
+		return elements
+	}
+	return []interface{}{value}
+}
+
+// sameValue compares a value as given to the encoder with the decoded one, at the precision of the field type
+func sameValue(field *FieldDefinition, source interface{}, decoded interface{}) bool {
+	switch field.FieldTypeInfo.Name {
+	case "string":
+		return source == decoded
+	case "enum":
+		index, err := valueForEnum(field, source)
+		if err != nil {
+			return false
+		}
+		if value, ok := decoded.(bool); ok {
+			_, trueIndex, _ := booleanEnumIndexes(field)
+			return value == (index == trueIndex)
+		}
+		return decoded == field.Options[index]
+	}
+
+	number, ok := source.(float64)
+	if ok == false {
+		return false
+	}
+	var value float64
+	switch v := decoded.(type) {
+	case int8:
+		value = float64(v)
+	case int16:
+		value = float64(v)
+	case int32:
+		value = float64(v)
+	case uint8:
+		value = float64(v)
+	case uint16:
+		value = float64(v)
+	case uint32:
+		value = float64(v)
+	case float32:
+		value = float64(v)
+	default:
+		return false
+	}
+
+	if field.FieldTypeInfo.Name != "float" {
+		// integers are compared to the source itself, an out of range or fractional source was not encoded as is
+		return value == number
+	}
+	if math.IsNaN(number) {
+		return math.IsNaN(value)
+	}
+	// rounded to float32, a finite source out of its range doesn't come back
+	return value == float64(float32(number)) && math.IsInf(value, 0) == math.IsInf(number, 0)
+}
@@ -0,0 +1,63 @@
+package uavtalk
+
+import (
+	"math"
+	"testing"
+)
+
+func TestVerifyEncoding(t *testing.T) {
+	loadTestDefinitions(t)
+	defer func() { PassUnknownObjects = false }()
+	definition := AllDefinitions.MustGetDefinitionForName("Waypoint")
+
+	with := func(name string, value interface{}) map[string]interface{} {
+		data := waypointData()
+		data[name] = value
+		return data
+	}
+	tests := []struct {
+		data map[string]interface{}
+		ok   bool
+	}{
+		{waypointData(), true},
+		{with("Velocity", math.NaN()), true},
+		{with("Velocity", math.Inf(-1)), true},
+		// rounded to float32
+		{with("Velocity", 0.1), true},
+		{with("Velocity", 1e40), false},
+		{with("Sign", float64(-128)), true},
+		{with("Sign", float64(-129)), false},
+		{with("Sign", 1.5), false},
+		{with("Counter", []interface{}{float64(40000), float64(0)}), false},
+	}
+
+	for i, test := range tests {
+		packet := NewPacket(definition, ObjectCmd, 1, test.data)
+		frame, err := packet.toBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyEncoding(packet, frame, FrameChecksum); (err == nil) != test.ok {
+			t.Errorf("case %d: got %v", i, err)
+		}
+	}
+
+	// the header of another packet
+	frame := encodeTestPacket(t, "Waypoint", ObjectCmd, 2, waypointData())
+	if err := verifyEncoding(NewPacket(definition, ObjectCmd, 1, waypointData()), frame, FrameChecksum); err == nil {
+		t.Error("frame of instance 2 verified as instance 1")
+	}
+
+	// an object unknown once decoded
+	PassUnknownObjects = true
+	unknown := definition.Clone()
+	unknown.ObjectID += 2
+	packet := NewPacket(unknown, ObjectCmd, 1, waypointData())
+	frame, err := packet.toBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyEncoding(packet, frame, FrameChecksum); err == nil {
+		t.Error("unknown object verified")
+	}
+}